@@ -0,0 +1,96 @@
+package regions
+
+import (
+	"math"
+)
+
+// Region is a fly.io region.
+type Region struct {
+	Code string
+	Name string
+	Location
+}
+
+// Catalog is every fly.io region, by code. Locations are the region's
+// datacenter or the airport it's named after.
+var Catalog = map[string]Region{
+	"ams": {"ams", "Amsterdam, Netherlands", Location{52.37, 4.90}},
+	"arn": {"arn", "Stockholm, Sweden", Location{59.65, 17.93}},
+	"atl": {"atl", "Atlanta, Georgia (US)", Location{33.64, -84.43}},
+	"bog": {"bog", "Bogotá, Colombia", Location{4.70, -74.15}},
+	"bom": {"bom", "Mumbai, India", Location{19.09, 72.87}},
+	"bos": {"bos", "Boston, Massachusetts (US)", Location{42.36, -71.01}},
+	"cdg": {"cdg", "Paris, France", Location{49.01, 2.55}},
+	"chi": {"chi", "Chicago, Illinois (US)", Location{41.88, -87.63}},
+	"den": {"den", "Denver, Colorado (US)", Location{39.86, -104.67}},
+	"dfw": {"dfw", "Dallas, Texas (US)", Location{32.90, -97.04}},
+	"ewr": {"ewr", "Secaucus, NJ (US)", Location{40.79, -74.06}},
+	"eze": {"eze", "Ezeiza, Argentina", Location{-34.82, -58.54}},
+	"fra": {"fra", "Frankfurt, Germany", Location{50.03, 8.57}},
+	"gdl": {"gdl", "Guadalajara, Mexico", Location{20.52, -103.31}},
+	"gig": {"gig", "Rio de Janeiro, Brazil", Location{-22.81, -43.25}},
+	"gru": {"gru", "São Paulo, Brazil", Location{-23.43, -46.47}},
+	"hkg": {"hkg", "Hong Kong, Hong Kong", Location{22.31, 113.92}},
+	"iad": {"iad", "Ashburn, Virginia (US)", Location{38.94, -77.46}},
+	"jnb": {"jnb", "Johannesburg, South Africa", Location{-26.14, 28.24}},
+	"lax": {"lax", "Los Angeles, California (US)", Location{33.94, -118.41}},
+	"lga": {"lga", "New York, New York (US)", Location{40.78, -73.87}},
+	"lhr": {"lhr", "London, United Kingdom", Location{51.47, -0.45}},
+	"mad": {"mad", "Madrid, Spain", Location{40.47, -3.56}},
+	"mia": {"mia", "Miami, Florida (US)", Location{25.79, -80.29}},
+	"nrt": {"nrt", "Tokyo, Japan", Location{35.77, 140.39}},
+	"ord": {"ord", "Chicago, Illinois (US)", Location{41.97, -87.91}},
+	"otp": {"otp", "Bucharest, Romania", Location{44.57, 26.10}},
+	"phx": {"phx", "Phoenix, Arizona (US)", Location{33.43, -112.01}},
+	"qro": {"qro", "Querétaro, Mexico", Location{20.62, -100.19}},
+	"scl": {"scl", "Santiago, Chile", Location{-33.39, -70.79}},
+	"sea": {"sea", "Seattle, Washington (US)", Location{47.45, -122.31}},
+	"sin": {"sin", "Singapore, Singapore", Location{1.36, 103.99}},
+	"sjc": {"sjc", "San Jose, California (US)", Location{37.36, -121.93}},
+	"syd": {"syd", "Sydney, Australia", Location{-33.95, 151.18}},
+	"waw": {"waw", "Warsaw, Poland", Location{52.17, 20.97}},
+	"yul": {"yul", "Montreal, Canada", Location{45.47, -73.74}},
+	"yyz": {"yyz", "Toronto, Canada", Location{43.68, -79.62}},
+}
+
+// Location is a point on Earth, in degrees.
+type Location struct {
+	Latitude, Longitude float64
+}
+
+// earthRadius is the mean radius of the Earth in km.
+const earthRadius = 6371.0
+
+// Distance returns the great-circle distance to o in km.
+func (l Location) Distance(o Location) float64 {
+	lat1, lat2 := radians(l.Latitude), radians(o.Latitude)
+	dLat, dLon := lat2-lat1, radians(o.Longitude-l.Longitude)
+
+	// haversine formula
+	h := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// Nearest returns the region of those with the given codes that's nearest to
+// loc. Codes that aren't in the Catalog are ignored. It returns false if none
+// are.
+func Nearest(loc Location, codes []string) (string, bool) {
+	var (
+		nearest string
+		best    = math.Inf(1)
+	)
+	for _, code := range codes {
+		r, ok := Catalog[code]
+		if !ok {
+			continue
+		}
+		if d := loc.Distance(r.Location); d < best || (d == best && code < nearest) {
+			nearest, best = code, d
+		}
+	}
+	return nearest, nearest != ""
+}
@@ -0,0 +1,54 @@
+package regions
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestDistance(t *testing.T) {
+	lhr, jfk := Catalog["lhr"].Location, Location{40.64, -73.78}
+	assert.True(t, lhr.Distance(jfk) > 5500 && lhr.Distance(jfk) < 5600)
+	assert.Equal(t, lhr.Distance(jfk), jfk.Distance(lhr))
+	assert.Equal(t, 0, lhr.Distance(lhr))
+
+	for code, r := range Catalog {
+		assert.Equal(t, code, r.Code)
+	}
+}
+
+func TestNearest(t *testing.T) {
+	berlin := Location{52.52, 13.40}
+
+	nearest, ok := Nearest(berlin, []string{"ams", "fra", "waw", "iad"})
+	assert.True(t, ok)
+	assert.Equal(t, "fra", nearest)
+
+	nearest, ok = Nearest(berlin, []string{"xyz", "iad"})
+	assert.True(t, ok)
+	assert.Equal(t, "iad", nearest)
+
+	_, ok = Nearest(berlin, []string{"xyz"})
+	assert.False(t, ok)
+}
+
+func TestParseLocation(t *testing.T) {
+	cases := map[string]Location{
+		"geo:52.52,13.4":         {52.52, 13.40},
+		"geo:-33.9,151.2,10;u=5": {-33.9, 151.2},
+		"DE":                     countries["DE"],
+		"de":                     countries["DE"],
+		"JFK":                    airports["JFK"],
+		"IAD":                    Catalog["iad"].Location,
+	}
+	for s, expected := range cases {
+		loc, err := ParseLocation(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, loc, s)
+	}
+
+	for _, s := range []string{"", "ZZ", "ZZZ", "Berlin", "geo:", "geo:1", "geo:x,1", "geo:1,181"} {
+		_, err := ParseLocation(s)
+		assert.Error(t, err, s)
+	}
+}
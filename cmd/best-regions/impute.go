@@ -0,0 +1,134 @@
+package main
+
+import (
+	"math"
+
+	regions "github.com/btoews/best-regions"
+	"golang.org/x/exp/slices"
+)
+
+// links is a set of links between regions, in either direction.
+type links map[[2]string]bool
+
+func link(a, b string) [2]string {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
+
+func (l links) has(a, b string) bool {
+	return l[link(a, b)]
+}
+
+const (
+	// fiberKmPerMs is how far light travels through fiber in a millisecond.
+	// It's used to estimate round trips if no links can be measured against.
+	fiberKmPerMs = 200.0
+
+	// routeStretch is how much longer than the great-circle distance routes
+	// between regions typically are.
+	routeStretch = 1.5
+
+	// unknownLatency is assumed when nothing else is known about a link.
+	unknownLatency = 1000.0
+)
+
+// impute estimates the cost of links with no data in either direction, which
+// are +Inf in linkCosts, and returns them. A link is estimated as the cheapest
+// path through other regions, following the triangle inequality. Regions with
+// no path between them are estimated by their great-circle distance, scaled to
+// match the measured links, and failing that are as slow as the slowest link.
+func impute(regionNames []string, linkCosts [][]float64) links {
+	n := len(regionNames)
+
+	imputed := links{}
+	for source := range linkCosts {
+		for sink, c := range linkCosts[source] {
+			if math.IsInf(c, 1) {
+				imputed[link(regionNames[source], regionNames[sink])] = true
+			}
+		}
+	}
+	if len(imputed) == 0 {
+		return imputed
+	}
+
+	// Floyd-Warshall over the links, only keeping paths for missing ones
+	paths := make([][]float64, n)
+	for i := range paths {
+		paths[i] = slices.Clone(linkCosts[i])
+	}
+	for via := 0; via < n; via++ {
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				if p := paths[i][via] + paths[via][j]; p < paths[i][j] {
+					paths[i][j] = p
+				}
+			}
+		}
+	}
+
+	msPerKm := geoMsPerKm(regionNames, linkCosts)
+	slowest := 0.0
+	for source := range linkCosts {
+		for sink, c := range linkCosts[source] {
+			if !imputed.has(regionNames[source], regionNames[sink]) {
+				slowest = math.Max(slowest, c)
+				continue
+			}
+
+			if p := paths[source][sink]; !math.IsInf(p, 1) {
+				linkCosts[source][sink] = p
+			} else if d, ok := distance(regionNames[source], regionNames[sink]); ok {
+				linkCosts[source][sink] = d * msPerKm
+			}
+		}
+	}
+
+	if slowest == 0 {
+		slowest = unknownLatency
+	}
+	for source := range linkCosts {
+		for sink, c := range linkCosts[source] {
+			if math.IsInf(c, 1) {
+				linkCosts[source][sink] = slowest
+			}
+		}
+	}
+
+	return imputed
+}
+
+// geoMsPerKm returns the latency per km of great-circle distance, averaged
+// over the measured links between regions in the catalog.
+func geoMsPerKm(regionNames []string, linkCosts [][]float64) float64 {
+	var totalMs, totalKm float64
+	for source := range linkCosts {
+		for sink, c := range linkCosts[source] {
+			if sink == source || math.IsInf(c, 1) {
+				continue
+			}
+			if d, ok := distance(regionNames[source], regionNames[sink]); ok {
+				totalMs += c
+				totalKm += d
+			}
+		}
+	}
+
+	if totalKm == 0 || totalMs == 0 {
+		return 2 * routeStretch / fiberKmPerMs
+	}
+	return totalMs / totalKm
+}
+
+// distance returns the great-circle distance between two regions in km, if
+// they're both in the catalog.
+func distance(a, b string) (float64, bool) {
+	ra, okA := regions.Catalog[a]
+	rb, okB := regions.Catalog[b]
+	if !okA || !okB {
+		return 0, false
+	}
+	return ra.Distance(rb.Location), true
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	regions "github.com/btoews/best-regions"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+// traffic is the amount of user traffic from each region.
+type traffic map[string]float64
+
+// Formats of the POST body, chosen by the format parameter or Content-Type.
+//
+//	prometheus - a fly.io prometheus query result (the default). Instant
+//	             vectors are used as is and range vectors (matrices) are
+//	             averaged. May include region costs.
+//	json       - a {"region": count} map, or prometheus data
+//	csv        - region,count lines, with an optional header
+//
+// Traffic may be keyed by location instead of region. See traffic.locate.
+// Region costs for formats that can't include them are given with the cost
+// parameter. See parseRegionCosts.
+const (
+	formatPrometheus = "prometheus"
+	formatJSON       = "json"
+	formatCSV        = "csv"
+)
+
+// readTraffic reads user traffic and optional region costs from the request
+// body.
+func readTraffic(r *http.Request) (traffic, regionCosts, error) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = contentTypeFormat(r.Header.Get("Content-Type"))
+	}
+
+	return readTrafficFormat(format, r.Body)
+}
+
+// readTrafficFormat reads user traffic and optional region costs in format.
+func readTrafficFormat(format string, r io.Reader) (traffic, regionCosts, error) {
+	switch format {
+	case formatPrometheus:
+		return readPromData(r)
+	case formatJSON:
+		return readTrafficJSON(r)
+	case formatCSV:
+		t, err := readTrafficCSV(r)
+		return t, nil, err
+	default:
+		return nil, nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// contentTypeFormat returns the format for a Content-Type. Anything not
+// recognized is assumed to be prometheus data, which is what the script sends
+// without a Content-Type of its own.
+func contentTypeFormat(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch mediaType {
+	case "application/json":
+		return formatJSON
+	case "text/csv":
+		return formatCSV
+	default:
+		return formatPrometheus
+	}
+}
+
+// readTrafficJSON reads a {"region": count} map. Prometheus data is
+// recognized by its "data" field and read as such.
+func readTrafficJSON(r io.Reader) (traffic, regionCosts, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, nil, fmt.Errorf("bad json traffic: %w", err)
+	}
+	if _, isProm := fields["data"]; isProm {
+		return readPromData(bytes.NewReader(body))
+	}
+
+	t := make(traffic, len(fields))
+	for region, raw := range fields {
+		var count float64
+		if err := json.Unmarshal(raw, &count); err != nil {
+			return nil, nil, fmt.Errorf("bad json traffic: count for %q must be a number", region)
+		}
+		if err := t.add(region, count); err != nil {
+			return nil, nil, fmt.Errorf("bad json traffic: %w", err)
+		}
+	}
+
+	return t, nil, nil
+}
+
+// readTrafficCSV reads region,count lines. The first line is skipped if it's
+// a header, i.e. its count isn't a number.
+func readTrafficCSV(r io.Reader) (traffic, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+	cr.TrimLeadingSpace = true
+
+	t := traffic{}
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("bad csv traffic: %w", err)
+		}
+
+		region, countField := strings.TrimSpace(record[0]), strings.TrimSpace(record[1])
+		count, err := strconv.ParseFloat(countField, 64)
+		if err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("bad csv traffic: line %d: count %q isn't a number", line, countField)
+		}
+
+		if _, dup := t[region]; dup {
+			return nil, fmt.Errorf("bad csv traffic: line %d: duplicate region %q", line, region)
+		}
+		if err := t.add(region, count); err != nil {
+			return nil, fmt.Errorf("bad csv traffic: line %d: %w", line, err)
+		}
+	}
+
+	return t, nil
+}
+
+// readPromData reads prometheus data, optionally with the cost of running in
+// each region.
+func readPromData(r io.Reader) (traffic, regionCosts, error) {
+	var pdj promDataJson
+	if err := json.NewDecoder(r).Decode(&pdj); err != nil {
+		return nil, nil, fmt.Errorf("bad prom data: %w", err)
+	}
+
+	if pdj.Status == "error" {
+		return nil, nil, fmt.Errorf("bad prom data: query failed: %s", pdj.Error)
+	}
+
+	switch pdj.Data.ResultType {
+	case "", "vector", "matrix":
+	default:
+		return nil, nil, fmt.Errorf("bad prom data: %s results aren't supported", pdj.Data.ResultType)
+	}
+
+	t := make(traffic, len(pdj.Data.Result))
+
+	for i, res := range pdj.Data.Result {
+		if res.Metric.Region == "" {
+			logrus.Warn("bad prom data: no region")
+			continue
+		}
+
+		var (
+			count float64
+			err   error
+		)
+		switch {
+		case res.Values != nil:
+			count, err = averagePromValues(res.Values)
+		default:
+			count, err = parsePromValue(res.Value)
+		}
+		if err == nil {
+			err = t.add(res.Metric.Region, count)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad prom data: result %d: %w", i, err)
+		}
+	}
+
+	return t, pdj.Costs, nil
+}
+
+// parsePromValue parses a [timestamp, "value"] pair.
+func parsePromValue(value []any) (float64, error) {
+	if l := len(value); l != 2 {
+		return 0, fmt.Errorf("%d fields in value", l)
+	}
+
+	sv, ok := value[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("%T val", value[1])
+	}
+
+	v, err := strconv.ParseFloat(sv, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse val: %w", err)
+	}
+
+	return v, nil
+}
+
+// averagePromValues averages the values of a range vector.
+func averagePromValues(values [][]any) (float64, error) {
+	if len(values) == 0 {
+		return 0, errors.New("no values")
+	}
+
+	var total float64
+	for _, value := range values {
+		v, err := parsePromValue(value)
+		if err != nil {
+			return 0, err
+		}
+		total += v
+	}
+
+	return total / float64(len(values)), nil
+}
+
+// add records count for region, checking that they're valid.
+func (t traffic) add(region string, count float64) error {
+	if region == "" {
+		return errors.New("empty region")
+	}
+	if math.IsNaN(count) || math.IsInf(count, 0) || count < 0 {
+		return fmt.Errorf("count for %q must be a non-negative number, not %v", region, count)
+	}
+	t[region] += count
+	return nil
+}
+
+// locate maps traffic from locations to the nearest of the given regions.
+// Only keys that can't be region codes are locations: geo URIs
+// ("geo:52.5,13.4"), country codes ("DE") and airport codes with an iata:
+// prefix ("iata:JFK"). Other keys are kept as they are, as are locations that
+// don't parse or have no region near them, so they're reported as unknown
+// regions.
+func (t traffic) locate(rs []string) traffic {
+	ret := make(traffic, len(t))
+	for key, count := range t {
+		loc, ok := parseTrafficLocation(key)
+		if !ok {
+			ret[key] += count
+			continue
+		}
+		region, ok := regions.Nearest(loc, rs)
+		if !ok {
+			ret[key] += count
+			continue
+		}
+		ret[region] += count
+	}
+
+	return ret
+}
+
+// parseTrafficLocation parses key if it's a location rather than a region.
+// See traffic.locate.
+func parseTrafficLocation(key string) (regions.Location, bool) {
+	switch airport, isAirport := strings.CutPrefix(key, "iata:"); {
+	case isAirport && len(airport) == 3:
+		key = airport
+	case strings.HasPrefix(key, "geo:"), len(key) == 2:
+	default:
+		return regions.Location{}, false
+	}
+
+	loc, err := regions.ParseLocation(key)
+	return loc, err == nil
+}
+
+func (t traffic) weights(regions []string) []float64 {
+	var sum float64
+	for _, r := range regions {
+		sum += t[r]
+	}
+
+	ret := make([]float64, len(regions))
+	if sum > 0 {
+		for i, r := range regions {
+			ret[i] = t[r] / sum
+		}
+	}
+
+	return ret
+}
+
+func (t traffic) unknownRegions(knownRegions []string) []string {
+	kr := make(map[string]bool, len(knownRegions))
+	for _, r := range knownRegions {
+		kr[r] = true
+	}
+
+	ret := []string{}
+	for r, _ := range t {
+		if _, known := kr[r]; !known {
+			ret = append(ret, r)
+		}
+	}
+
+	return ret
+}
+
+// regionCosts is the cost of running in each region, e.g. in dollars.
+type regionCosts map[string]float64
+
+// costs returns the cost of each region. Regions without a cost are free.
+func (rc regionCosts) costs(regions []string) ([]float64, error) {
+	ret := make([]float64, len(regions))
+	for region, c := range rc {
+		i := slices.Index(regions, region)
+		if i < 0 {
+			return nil, fmt.Errorf("cost for unknown region %q", region)
+		}
+		if !(c >= 0) || math.IsInf(c, 1) {
+			return nil, fmt.Errorf("bad cost %v for region %q", c, region)
+		}
+		ret[i] = c
+	}
+	return ret, nil
+}
+
+// data from fly.io prometheus query:
+//
+//	query=sum(increase(fly_edge_http_responses_count)) by (region)
+//
+// or the range query equivalent, with an optional map of region to cost:
+//
+//	"costs": {"iad": 20, "fra": 25}
+type promDataJson struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+
+	Data struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric struct {
+				Region string `json:"region"`
+			} `json:"metric"`
+			Value  []any   `json:"value"`
+			Values [][]any `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+
+	Costs regionCosts `json:"costs"`
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestDecodePromData(t *testing.T) {
+	const d = `{"status":"success","isPartial":false,"data":{"resultType":"vector","result":[{"metric":{"region":"ams"},"value":[1689867197,"21"]},{"metric":{"region":"arn"},"value":[1689867197,"20"]},{"metric":{"region":"atl"},"value":[1689867197,"4"]},{"metric":{"region":"bom"},"value":[1689867197,"12"]},{"metric":{"region":"cdg"},"value":[1689867197,"31"]},{"metric":{"region":"chi"},"value":[1689867197,"5"]},{"metric":{"region":"dfw"},"value":[1689867197,"32"]},{"metric":{"region":"fra"},"value":[1689867197,"85"]},{"metric":{"region":"gdl"},"value":[1689867197,"2"]},{"metric":{"region":"gru"},"value":[1689867197,"51"]},{"metric":{"region":"hkg"},"value":[1689867197,"33"]},{"metric":{"region":"iad"},"value":[1689867197,"19"]},{"metric":{"region":"jnb"},"value":[1689867197,"8"]},{"metric":{"region":"lax"},"value":[1689867197,"47"]},{"metric":{"region":"lga"},"value":[1689867197,"25"]},{"metric":{"region":"yyz"},"value":[1689867197,"26"]}]}}`
+	pd, rc, err := readPromData(bytes.NewReader([]byte(d)))
+	assert.NoError(t, err)
+	assert.Zero(t, rc)
+	assert.Equal(t, traffic{
+		"ams": 21,
+		"arn": 20,
+		"atl": 4,
+		"bom": 12,
+		"cdg": 31,
+		"chi": 5,
+		"dfw": 32,
+		"fra": 85,
+		"gdl": 2,
+		"gru": 51,
+		"hkg": 33,
+		"iad": 19,
+		"jnb": 8,
+		"lax": 47,
+		"lga": 25,
+		"yyz": 26,
+	}, pd)
+
+	pd, rc, err = readPromData(bytes.NewReader([]byte(`{"data":{"result":[{"metric":{"region":"ams"},"value":[1689867197,"21"]}]},"costs":{"ams":12.5,"iad":10}}`)))
+	assert.NoError(t, err)
+	assert.Equal(t, traffic{"ams": 21}, pd)
+	assert.Equal(t, regionCosts{"ams": 12.5, "iad": 10}, rc)
+}
+
+func TestDecodePromMatrix(t *testing.T) {
+	const d = `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"region":"ams"},"values":[[1689867197,"20"],[1689867257,"22.5"]]},{"metric":{"region":"fra"},"values":[[1689867197,"4"]]}]}}`
+	tr, _, err := readPromData(strings.NewReader(d))
+	assert.NoError(t, err)
+	assert.Equal(t, traffic{"ams": 21.25, "fra": 4}, tr)
+
+	for _, d := range []string{
+		`{"status":"error","error":"bad query"}`,
+		`{"data":{"resultType":"scalar","result":[]}}`,
+		`{"data":{"resultType":"matrix","result":[{"metric":{"region":"ams"},"values":[]}]}}`,
+		`{"data":{"resultType":"matrix","result":[{"metric":{"region":"ams"},"values":[[1689867197,"x"]]}]}}`,
+		`{"data":{"resultType":"vector","result":[{"metric":{"region":"ams"},"value":[1689867197,"-1"]}]}}`,
+		`{"data":{"resultType":"vector","result":[{"metric":{"region":"ams"},"value":[1689867197]}]}}`,
+		`{"data":`,
+	} {
+		_, _, err := readPromData(strings.NewReader(d))
+		assert.Error(t, err, d)
+	}
+}
+
+func TestReadTrafficJSON(t *testing.T) {
+	tr, rc, err := readTrafficJSON(strings.NewReader(`{"ams": 21, "fra": 4.5}`))
+	assert.NoError(t, err)
+	assert.Equal(t, traffic{"ams": 21, "fra": 4.5}, tr)
+	assert.Zero(t, rc)
+
+	// prometheus data is recognized
+	tr, rc, err = readTrafficJSON(strings.NewReader(`{"data":{"result":[{"metric":{"region":"ams"},"value":[1689867197,"21"]}]},"costs":{"ams":10}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, traffic{"ams": 21}, tr)
+	assert.Equal(t, regionCosts{"ams": 10}, rc)
+
+	for _, d := range []string{`{"ams": "21"}`, `{"ams": -1}`, `{"": 1}`, `["ams"]`, `{"ams": 1`} {
+		_, _, err := readTrafficJSON(strings.NewReader(d))
+		assert.Error(t, err, d)
+	}
+}
+
+func TestReadTrafficCSV(t *testing.T) {
+	tr, err := readTrafficCSV(strings.NewReader("region,count\nams, 21\nfra,4.5\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, traffic{"ams": 21, "fra": 4.5}, tr)
+
+	tr, err = readTrafficCSV(strings.NewReader("ams,21\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, traffic{"ams": 21}, tr)
+
+	for _, d := range []string{"ams,21\nfra,x\n", "ams,21\nams,1\n", "ams,21,3\n", "ams,-1\n", ",1\n"} {
+		_, err := readTrafficCSV(strings.NewReader(d))
+		assert.Error(t, err, d)
+	}
+}
+
+func TestReadTraffic(t *testing.T) {
+	const (
+		prom = `{"data":{"result":[{"metric":{"region":"ams"},"value":[1689867197,"21"]}]}}`
+		csv  = "ams,21\n"
+	)
+
+	cases := []struct {
+		url, contentType, body string
+	}{
+		{"/", "", prom},
+		{"/", "application/x-www-form-urlencoded", prom},
+		{"/", "application/json; charset=utf-8", prom},
+		{"/", "application/json", `{"ams": 21}`},
+		{"/", "text/csv", csv},
+		{"/?format=csv", "application/json", csv},
+		{"/?format=prometheus", "text/csv", prom},
+		{"/?format=json", "", `{"ams": 21}`},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("POST", c.url, strings.NewReader(c.body))
+		r.Header.Set("Content-Type", c.contentType)
+
+		tr, _, err := readTraffic(r)
+		assert.NoError(t, err, c)
+		assert.Equal(t, traffic{"ams": 21}, tr, c)
+	}
+
+	_, _, err := readTraffic(httptest.NewRequest("POST", "/?format=xml", bytes.NewReader(nil)))
+	assert.Error(t, err)
+}
+
+func TestLocateTraffic(t *testing.T) {
+	mesh := []string{"ams", "iad", "sin", "syd"}
+
+	tr := traffic{
+		"ams":             1,
+		"fra":             2,
+		"DE":              3,
+		"us":              4,
+		"iata:JFK":        5,
+		"jfk":             6,
+		"geo:-37.8,145.0": 7,
+	}.locate(mesh)
+	assert.Equal(t, traffic{"ams": 4, "fra": 2, "iad": 9, "jfk": 6, "syd": 7}, tr)
+
+	// keys that aren't clearly locations, or don't parse, are left to be
+	// reported as unknown regions
+	unknown := traffic{"Atlantis": 1, "xyz": 2, "ZZ": 3, "iata:XYZ": 4, "iata:DE": 5, "geo:91,0": 6}
+	assert.Equal(t, unknown, unknown.locate(mesh))
+	assert.Equal(t, traffic{"xyz": 1}, traffic{"xyz": 1}.locate(nil))
+	assert.Equal(t, traffic{"DE": 1}, traffic{"DE": 1}.locate(nil))
+}
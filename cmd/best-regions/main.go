@@ -4,10 +4,11 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
@@ -24,10 +25,22 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "solve" {
+		os.Exit(solveCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	mux := new(http.ServeMux)
 
 	s := regions.NewServer(0, 0, mux)
 	s.LogOutput(os.Stderr)
+	s.AddMetrics(writeSolveMetrics)
+
+	if pm := os.Getenv("PROBE_METHOD"); pm != "" {
+		if err := s.SetProbeMethod(regions.ProbeMethod(pm)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
 
 	go func() {
 		if err := s.Run(); err != nil {
@@ -36,7 +49,16 @@ func main() {
 		}
 	}()
 
-	m := &model{s: s, stop: make(chan struct{})}
+	solver := os.Getenv("SOLVER")
+	if solver == "" {
+		solver = "bnb"
+	}
+	if !slices.Contains(exactSolvers, solver) {
+		fmt.Fprintf(os.Stderr, "unknown solver %q\n", solver)
+		os.Exit(1)
+	}
+
+	m := &model{s: s, solver: solver, stop: make(chan struct{})}
 	go m.run()
 
 	mux.Handle("/", handler(m))
@@ -73,89 +95,713 @@ func handler(m *model) http.Handler {
 		}
 
 		m.m.RLock()
-		bf := m.bf
-		g := m.g
+		ms := m.mesh
 		m.m.RUnlock()
 
 		w.Header().Set("Content-Type", "application/json")
 
-		pd, err := readPromData(r.Body)
-		if errJSON(w, "readPromData", err) {
+		tr, rc, err := readTraffic(r)
+		if errJSON(w, "readTraffic", err) {
+			return
+		}
+
+		results, err := ms.query(r.Context(), tr, rc, r.URL.Query())
+		var (
+			oe     *opError
+			logMsg string
+		)
+		if errors.As(err, &oe) {
+			logMsg = oe.op
+		}
+		if errJSON(w, logMsg, err) {
+			return
+		}
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		if err := enc.Encode(results); err != nil {
+			logrus.WithError(err).Warn("writing results")
 			return
 		}
-		weights := pd.weights(bf.Vertices)
+	})
+}
+
+// opError is an error from step op of answering a query, rather than from
+// bad parameters. The handler logs them.
+type opError struct {
+	op  string
+	err error
+}
+
+func (e *opError) Error() string { return e.err.Error() }
+func (e *opError) Unwrap() error { return e.err }
+
+// failed wraps err from step op as an opError.
+func failed(op string, err error) error {
+	return &opError{op, err}
+}
+
+// query answers a query with the same parameters as the POST endpoint, for
+// traffic from tr.
+func (ms *mesh) query(ctx context.Context, tr traffic, rc regionCosts, q url.Values) (Results, error) {
+	bf, g, h, imputed := ms.bf, ms.g, ms.h, ms.imputed
+
+	tr = tr.locate(bf.Vertices)
+	weights := tr.weights(bf.Vertices)
+
+	results := Results{}
+
+	if ur := tr.unknownRegions(bf.Vertices); len(ur) != 0 {
+		results.Error = fmt.Sprintf("unknown regions: %s", strings.Join(ur, ", "))
+	}
 
-		results := Results{}
+	opts, err := parseObjective(q.Get("objective"))
+	if err != nil {
+		return Results{}, err
+	}
 
-		if ur := pd.unknownRegions(bf.Vertices); len(ur) != 0 {
-			results.Error = fmt.Sprintf("unknown regions: %s", strings.Join(ur, ", "))
+	var capacities []float64
+	if paramCapacity := q["capacity"]; len(paramCapacity) != 0 {
+		capacities, err = parseCapacities(paramCapacity, bf.Vertices)
+		if err != nil {
+			return Results{}, err
 		}
+		opts = append(opts, graph.WithCapacities(capacities))
+	}
+
+	mc, err := parseMachines(q.Get("machines"), q.Get("machine_capacity"))
+	if err != nil {
+		return Results{}, err
+	}
+	if mc.count != 0 {
+		opts = append(opts, graph.WithMachines(mc.count, mc.capacity))
+	}
+
+	if paramFailover := q.Get("failover"); paramFailover != "" {
+		failover, err := parseFailover(paramFailover)
+		if err != nil {
+			return Results{}, err
+		}
+		opts = append(opts, failover)
+	}
 
-		if paramK := r.URL.Query().Get("k"); paramK != "" {
-			k64, err := strconv.ParseInt(paramK, 10, 8)
-			if errJSON(w, "parse k", err) {
-				return
+	if paramCost := q["cost"]; len(paramCost) != 0 {
+		rc, err = parseRegionCosts(paramCost, rc)
+		if err != nil {
+			return Results{}, err
+		}
+	}
+
+	paramBudget, paramCostWeight := q.Get("budget"), q.Get("cost_weight")
+	if paramBudget != "" || paramCostWeight != "" {
+		costOpts, err := parseSinkCosts(paramBudget, paramCostWeight, rc, bf.Vertices)
+		if err != nil {
+			return Results{}, err
+		}
+		opts = append(opts, costOpts...)
+	}
+
+	if paramRequire := q["require"]; len(paramRequire) != 0 {
+		required, err := parseRegionList(paramRequire, bf.Vertices)
+		if err != nil {
+			return Results{}, err
+		}
+		opts = append(opts, graph.WithRequired(required...))
+	}
+
+	if paramExclude := q["exclude"]; len(paramExclude) != 0 {
+		excluded, err := parseRegionList(paramExclude, bf.Vertices)
+		if err != nil {
+			return Results{}, err
+		}
+		opts = append(opts, graph.WithExcluded(excluded...))
+	}
+
+	paramExplain := q.Get("explain") == "true"
+
+	mcSensitivity, paramTrials, err := parseSensitivity(q, ms.jitter)
+	if err != nil {
+		return Results{}, err
+	}
+
+	// with a budget or cost weight, k can be left for the solver to choose
+	var (
+		kMin, kMax int
+		chooseK    bool
+	)
+	switch paramK := q.Get("k"); {
+	case paramK != "":
+		kMin, kMax, err = parseK(paramK, len(bf.Vertices))
+		if err != nil {
+			return Results{}, err
+		}
+	case q.Get("sweep") == "true":
+		kMin, kMax = 1, maxSweepK
+	case paramBudget != "" || paramCostWeight != "":
+		kMin, kMax, chooseK = 1, maxSweepK, true
+	}
+	if nv := len(bf.Vertices); kMax > nv {
+		kMax = nv
+	}
+
+	if kMin < kMax && mc.count != 0 {
+		return Results{}, errors.New("machines needs a single k")
+	}
+	if kMin < kMax && paramTrials {
+		return Results{}, errors.New("trials needs a single k")
+	}
+
+	if kMin < kMax {
+		results.Sweep, err = solveSweep(ctx, ms.solver, bf, g, h, kMin, kMax, weights, opts)
+		if err != nil {
+			return Results{}, failed("sweep", err)
+		}
+		for i := range results.Sweep {
+			if err := describe(ctx, &results.Sweep[i].Result, bf, imputed, weights, opts); err != nil {
+				return Results{}, failed("describe", err)
+			}
+			if err := explain(ctx, &results.Sweep[i].Result, bf, weights, opts, paramExplain); err != nil {
+				return Results{}, failed("explain", err)
 			}
-			k := int(k64)
+		}
 
-			if nv := len(bf.Vertices); k < 1 || k > nv {
-				errJSON(w, "", fmt.Errorf("k must be in [1 %d]", nv))
-				return
+		if chooseK {
+			if best := bestOfSweep(results.Sweep); best != nil {
+				results.Results = append(results.Results, *best)
 			}
+			results.Sweep = nil
+		}
+	}
 
-			var (
-				cost  float64
-				combo []string
-			)
+	if k := kMin; k != 0 && k == kMax {
+		n, err := parseAlternatives(q.Get("alternatives"))
+		if err != nil {
+			return Results{}, err
+		}
 
-			if k < 4 {
-				if cost, combo, err = bf.Solve(k, weights); errJSON(w, "solve (bf)", err) {
-					return
-				}
-			} else {
-				if cost, combo, err = g.Solve(k, weights); errJSON(w, "solve (graph)", err) {
-					return
-				}
-			}
+		var solved []Result
 
-			results.Results = append(results.Results, Result{Regions: combo, Cost: cost})
+		switch {
+		case k < 4:
+			start := time.Now()
+			solutions, err := bf.SolveN(ctx, k, n, weights, opts...)
+			timeSolve("bf", start)
+			if err != nil {
+				return Results{}, failed("solve (bf)", err)
+			}
+			for _, sol := range solutions {
+				solved = append(solved, Result{Regions: sol.Picks, Cost: sol.Cost})
+			}
+		case n == 1:
+			result, err := solveOrApproximate(ctx, ms.solver, g, h, k, weights, opts)
+			if err != nil {
+				return Results{}, failed("solve ("+ms.solver+")", err)
+			}
+			solved = append(solved, result)
+		default:
+			solved, err = solveAlternatives(ctx, ms.solver, g, h, k, n, weights, opts)
+			if err != nil {
+				return Results{}, failed("solve ("+ms.solver+")", err)
+			}
 		}
 
-		for _, paramCompare := range r.URL.Query()["compare"] {
-			combo := strings.Split(paramCompare, ",")
-			for i := range combo {
-				combo[i] = strings.TrimSpace(combo[i])
+		for _, result := range solved {
+			if err := describe(ctx, &result, bf, imputed, weights, opts); err != nil {
+				return Results{}, failed("describe", err)
 			}
-			combo = slices.DeleteFunc(combo, func(c string) bool { return c == "" })
-			if len(combo) == 0 {
-				continue
+			if err := explain(ctx, &result, bf, weights, opts, paramExplain); err != nil {
+				return Results{}, failed("explain", err)
+			}
+			if err := mc.allocate(ctx, &result, bf, weights, opts); err != nil {
+				return Results{}, failed("allocate", err)
 			}
+			results.Results = append(results.Results, result)
+		}
 
-			cost, err := bf.CombinationCost(combo, weights)
-			if errJSON(w, "CombinationCost", err) {
-				return
+		if paramTrials && len(results.Results) != 0 {
+			results.Sensitivity, err = sensitivity(ctx, ms, mcSensitivity, k, weights, opts, results.Results[0].Regions)
+			if err != nil {
+				return Results{}, failed("sensitivity", err)
 			}
-			results.Results = append(results.Results, Result{Regions: combo, Cost: cost})
 		}
+	}
 
-		enc := json.NewEncoder(w)
-		enc.SetIndent("", "  ")
+	for _, paramCompare := range q["compare"] {
+		combo := strings.Split(paramCompare, ",")
+		for i := range combo {
+			combo[i] = strings.TrimSpace(combo[i])
+		}
+		combo = slices.DeleteFunc(combo, func(c string) bool { return c == "" })
+		if len(combo) == 0 {
+			continue
+		}
 
-		if err := enc.Encode(results); err != nil {
-			logrus.WithError(err).Warn("writing results")
-			return
+		cost, err := combinationCost(ctx, bf, combo, weights, opts)
+		if err != nil {
+			return Results{}, failed("CombinationCost", err)
 		}
-	})
+
+		result := Result{Regions: combo, Cost: cost}
+		if err := describe(ctx, &result, bf, imputed, weights, opts); err != nil {
+			return Results{}, failed("describe", err)
+		}
+		if err := explain(ctx, &result, bf, weights, opts, paramExplain); err != nil {
+			return Results{}, failed("explain", err)
+		}
+		if err := mc.allocate(ctx, &result, bf, weights, opts); err != nil {
+			return Results{}, failed("allocate", err)
+		}
+
+		results.Results = append(results.Results, result)
+	}
+
+	return results, nil
 }
 
 type Results struct {
-	Results []Result `json:"results,omitempty"`
-	Error   string   `json:"error,omitempty"`
+	Results []Result      `json:"results,omitempty"`
+	Sweep   []SweepResult `json:"sweep,omitempty"`
+	Error   string        `json:"error,omitempty"`
+
+	// Sensitivity is how robust the first result is to noise, if trials were
+	// asked for.
+	Sensitivity *Sensitivity `json:"sensitivity,omitempty"`
+}
+
+// SweepResult is the best result for one k in a range. k that can't satisfy
+// the constraints are left out.
+type SweepResult struct {
+	K int `json:"k"`
+	Result
+
+	// Improvement is how much lower the cost is than with one region fewer. It's
+	// left out for the smallest k, or if one region fewer isn't feasible.
+	Improvement *float64 `json:"improvement,omitempty"`
 }
 
 type Result struct {
 	Regions []string `json:"regions"`
 	Cost    float64  `json:"cost"`
+
+	// Approximate is set if exact solving took too long and the regions are
+	// the best found in the time allowed. Gap is how much worse than optimal
+	// the cost might be, as a fraction of the cost.
+	Approximate bool    `json:"approximate,omitempty"`
+	Gap         float64 `json:"gap,omitempty"`
+
+	// Assignments maps regions with users to the region serving them.
+	Assignments map[string]Assignment `json:"assignments"`
+
+	// AverageLatency is the traffic-weighted average latency users see.
+	AverageLatency float64 `json:"average_latency_ms"`
+
+	// WorstServed are the regions with users that see the highest latency,
+	// worst first.
+	WorstServed []string `json:"worst_served"`
+
+	// Machines is how many machines to run in each region, if a machine count
+	// was given.
+	Machines map[string]int `json:"machines,omitempty"`
+
+	// Explanation is why the regions were picked, if asked for.
+	Explanation *Explanation `json:"explanation,omitempty"`
+}
+
+// Explanation justifies a result's regions. Costs are null if the regions
+// can't satisfy the constraints.
+type Explanation struct {
+	// Removal is how much the cost increases without each region.
+	Removal map[string]*float64 `json:"removal"`
+
+	// Swaps are the best swap for each region that wasn't picked, least costly
+	// first.
+	Swaps []Swap `json:"swaps"`
+}
+
+// Swap is the result of picking Region instead of Replaces.
+type Swap struct {
+	Region   string   `json:"region"`
+	Replaces string   `json:"replaces,omitempty"`
+	Delta    *float64 `json:"delta"`
+}
+
+type Assignment struct {
+	Region  string  `json:"region"`
+	Latency float64 `json:"latency_ms"`
+	Weight  float64 `json:"weight"`
+
+	// Backup is the region serving users if Region is down.
+	Backup        string  `json:"backup,omitempty"`
+	BackupLatency float64 `json:"backup_latency_ms,omitempty"`
+
+	// Imputed and BackupImputed are set if there was no data for the latency
+	// and it's an estimate.
+	Imputed       bool `json:"imputed,omitempty"`
+	BackupImputed bool `json:"backup_imputed,omitempty"`
+}
+
+// numWorstServed is how many regions are listed in Result.WorstServed.
+const numWorstServed = 5
+
+// combinationCost is the cost of a combination the query asked to compare.
+func combinationCost(ctx context.Context, bf *graph.BruteForcer, combo []string, weights []float64, opts []graph.Option) (float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, describeTimeout)
+	defer cancel()
+
+	return bf.CombinationCost(ctx, combo, weights, opts...)
+}
+
+// describe fills in how users are served by result's regions, flagging
+// latencies that were estimated.
+func describe(ctx context.Context, result *Result, bf *graph.BruteForcer, imputed links, weights []float64, opts []graph.Option) error {
+	ctx, cancel := context.WithTimeout(ctx, describeTimeout)
+	defer cancel()
+
+	assignments, err := bf.Assign(ctx, result.Regions, weights, opts...)
+	if err != nil {
+		return err
+	}
+
+	result.Assignments = make(map[string]Assignment, len(assignments))
+	result.AverageLatency = 0
+
+	var served []graph.Assignment
+	for _, a := range assignments {
+		if a.Weight <= 0 {
+			continue
+		}
+		result.Assignments[a.Vertex] = Assignment{
+			Region:        a.Sink,
+			Latency:       a.Cost,
+			Weight:        a.Weight,
+			Backup:        a.Backup,
+			BackupLatency: a.BackupCost,
+			Imputed:       imputed.has(a.Vertex, a.Sink),
+			BackupImputed: a.Backup != "" && imputed.has(a.Vertex, a.Backup),
+		}
+		result.AverageLatency += a.Cost * a.Weight
+		served = append(served, a)
+	}
+
+	slices.SortStableFunc(served, func(a, b graph.Assignment) bool {
+		return a.Cost > b.Cost
+	})
+	if len(served) > numWorstServed {
+		served = served[:numWorstServed]
+	}
+
+	result.WorstServed = make([]string, len(served))
+	for i, a := range served {
+		result.WorstServed[i] = a.Vertex
+	}
+
+	return nil
+}
+
+// explain fills in why result's regions were picked, if asked to.
+func explain(ctx context.Context, result *Result, bf *graph.BruteForcer, weights []float64, opts []graph.Option, paramExplain bool) error {
+	if !paramExplain {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, describeTimeout)
+	defer cancel()
+
+	contributions, swaps, err := bf.Explain(ctx, result.Regions, weights, opts...)
+	if err != nil {
+		return err
+	}
+
+	result.Explanation = &Explanation{Removal: make(map[string]*float64, len(contributions))}
+	for _, c := range contributions {
+		result.Explanation.Removal[c.Sink] = finite(c.Removal)
+	}
+
+	slices.SortStableFunc(swaps, func(a, b graph.Swap) bool {
+		return a.Delta < b.Delta
+	})
+	for _, s := range swaps {
+		result.Explanation.Swaps = append(result.Explanation.Swaps, Swap{
+			Region:   s.Vertex,
+			Replaces: s.Sink,
+			Delta:    finite(s.Delta),
+		})
+	}
+
+	return nil
+}
+
+// finite returns a pointer to f, or nil if it's infinite, which JSON can't
+// encode.
+func finite(f float64) *float64 {
+	if math.IsInf(f, 0) {
+		return nil
+	}
+	return &f
+}
+
+// machines is how many machines to deploy in total and the share of traffic
+// each can serve. count is 0 if machines weren't asked for.
+type machines struct {
+	count    int
+	capacity float64
+}
+
+// parseMachines parses the machines and machine_capacity parameters, which
+// must be given together.
+//
+//	machines=10&machine_capacity=0.2
+func parseMachines(paramMachines, paramCapacity string) (machines, error) {
+	if paramMachines == "" && paramCapacity == "" {
+		return machines{}, nil
+	}
+	if paramMachines == "" || paramCapacity == "" {
+		return machines{}, errors.New("machines and machine_capacity must be given together")
+	}
+
+	count, err := strconv.Atoi(paramMachines)
+	if err != nil {
+		return machines{}, fmt.Errorf("bad machines %q: %w", paramMachines, err)
+	}
+	if count < 1 {
+		return machines{}, fmt.Errorf("machines %q must be positive", paramMachines)
+	}
+
+	capacity, err := strconv.ParseFloat(paramCapacity, 64)
+	if err != nil {
+		return machines{}, fmt.Errorf("bad machine_capacity %q: %w", paramCapacity, err)
+	}
+	if !(capacity > 0 && capacity <= 1) {
+		return machines{}, fmt.Errorf("machine_capacity %q must be in (0 1]", paramCapacity)
+	}
+
+	return machines{count: count, capacity: capacity}, nil
+}
+
+// allocate fills in how many machines each of result's regions gets. opts
+// include the machines, so result's regions were only picked if the machines
+// can be allocated to them, and Assign serves them the same way.
+func (mc machines) allocate(ctx context.Context, result *Result, bf *graph.BruteForcer, weights []float64, opts []graph.Option) error {
+	if mc.count == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, describeTimeout)
+	defer cancel()
+
+	assignments, err := bf.Assign(ctx, result.Regions, weights, opts...)
+	if err != nil {
+		return err
+	}
+
+	allocations, err := graph.AllocateMachines(assignments, mc.count, mc.capacity)
+	if err != nil {
+		return fmt.Errorf("allocating %d machines: %w", mc.count, err)
+	}
+
+	result.Machines = make(map[string]int, len(allocations))
+	for _, a := range allocations {
+		result.Machines[a.Sink] = a.Machines
+	}
+
+	return nil
+}
+
+// How long to wait for the exact solver before falling back to the heuristic
+// solver, and how long the heuristic solver gets. With capacities, assigning
+// users to a result's regions is a search too, which gets describeTimeout.
+const (
+	exactTimeout     = 10 * time.Second
+	heuristicTimeout = 5 * time.Second
+	describeTimeout  = 5 * time.Second
+)
+
+// solveOrApproximate solves with g, falling back to an approximate result if g
+// takes longer than exactTimeout. The approximate result is the better of g's
+// incumbent, if it has one, and h's solution.
+func solveOrApproximate(ctx context.Context, solver string, g graph.Solver, h *graph.HeuristicSolver, k int, weights []float64, opts []graph.Option) (Result, error) {
+	exactCtx, cancel := context.WithTimeout(ctx, exactTimeout)
+	defer cancel()
+
+	start := time.Now()
+	cost, combo, err := g.Solve(exactCtx, k, weights, opts...)
+	timeSolve(solver, start)
+	switch {
+	case err == nil:
+		return Result{Regions: combo, Cost: cost}, nil
+	case ctx.Err() != nil || !errors.Is(err, context.DeadlineExceeded):
+		return Result{}, err
+	}
+
+	return approximate(ctx, h, k, weights, opts, cost, combo)
+}
+
+// approximate solves with h, returning the better of its solution and the
+// incumbent cost and combo, if there is one.
+func approximate(ctx context.Context, h *graph.HeuristicSolver, k int, weights []float64, opts []graph.Option, cost float64, combo []string) (Result, error) {
+	heuristicCtx, cancel := context.WithTimeout(ctx, heuristicTimeout)
+	defer cancel()
+
+	start := time.Now()
+	hCost, hCombo, hErr := h.Solve(heuristicCtx, k, weights, opts...)
+	timeSolve("heuristic", start)
+
+	switch {
+	case hCombo != nil && (combo == nil || hCost < cost):
+		cost, combo = hCost, hCombo
+	case combo == nil:
+		return Result{}, hErr
+	}
+
+	result := Result{Regions: combo, Cost: cost, Approximate: true}
+
+	if lowerBound, err := h.LowerBound(k, weights, opts...); err == nil && cost > 0 {
+		result.Gap = (cost - math.Min(lowerBound, cost)) / cost
+	}
+
+	return result, nil
+}
+
+// solveAlternatives solves for the n best combinations with g. If g takes
+// longer than exactTimeout, the combinations it found are marked approximate,
+// since better ones may have been missed. If it found none, the only result is
+// h's solution.
+func solveAlternatives(ctx context.Context, solver string, g graph.Solver, h *graph.HeuristicSolver, k, n int, weights []float64, opts []graph.Option) ([]Result, error) {
+	as, ok := g.(graph.AlternativesSolver)
+	if !ok {
+		return nil, fmt.Errorf("%s solver doesn't support alternatives", solver)
+	}
+
+	exactCtx, cancel := context.WithTimeout(ctx, exactTimeout)
+	defer cancel()
+
+	start := time.Now()
+	solutions, err := as.SolveN(exactCtx, k, n, weights, opts...)
+	timeSolve(solver, start)
+	switch {
+	case err == nil:
+	case ctx.Err() != nil || !errors.Is(err, context.DeadlineExceeded):
+		return nil, err
+	case len(solutions) == 0:
+		result, err := approximate(ctx, h, k, weights, opts, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+		return []Result{result}, nil
+	}
+
+	ret := make([]Result, len(solutions))
+	for i, sol := range solutions {
+		ret[i] = Result{Regions: sol.Picks, Cost: sol.Cost, Approximate: err != nil}
+	}
+
+	return ret, nil
+}
+
+// maxSweepK is the largest k solved for when sweep=true.
+const maxSweepK = 12
+
+// solveSweep solves for each k from kMin to kMax. Like single solves, k below 4
+// are brute forced and larger k use g, reusing its work between solves. k that
+// g doesn't finish within exactTimeout per k get h's approximate solution
+// instead.
+func solveSweep(ctx context.Context, solver string, bf *graph.BruteForcer, g graph.Solver, h *graph.HeuristicSolver, kMin, kMax int, weights []float64, opts []graph.Option) ([]SweepResult, error) {
+	// results[i] is the result for k=kMin+i, without regions if k is infeasible.
+	results := make([]Result, 0, kMax-kMin+1)
+	add := func(solutions []graph.Solution) {
+		for _, sol := range solutions {
+			results = append(results, Result{Regions: sol.Picks, Cost: sol.Cost})
+		}
+	}
+
+	if kMin < 4 {
+		bfMax := kMax
+		if bfMax > 3 {
+			bfMax = 3
+		}
+		solutions, err := graph.Sweep(ctx, bf, kMin, bfMax, weights, opts...)
+		if err != nil {
+			return nil, err
+		}
+		add(solutions)
+	}
+
+	if gMin := kMin + len(results); gMin <= kMax {
+		exactCtx, cancel := context.WithTimeout(ctx, time.Duration(kMax-gMin+1)*exactTimeout)
+		defer cancel()
+
+		solutions, err := graph.Sweep(exactCtx, g, gMin, kMax, weights, opts...)
+		if err != nil && (ctx.Err() != nil || !errors.Is(err, context.DeadlineExceeded)) {
+			return nil, fmt.Errorf("%s: %w", solver, err)
+		}
+		add(solutions)
+	}
+
+	// fall back to the heuristic for k that g didn't finish in time
+	for k := kMin + len(results); k <= kMax; k++ {
+		result, err := approximate(ctx, h, k, weights, opts, 0, nil)
+		if err != nil && !errors.Is(err, graph.ErrInfeasible) {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	var ret []SweepResult
+	for i, result := range results {
+		if result.Regions == nil {
+			continue
+		}
+
+		sr := SweepResult{K: kMin + i, Result: result}
+		if i > 0 && results[i-1].Regions != nil {
+			improvement := results[i-1].Cost - result.Cost
+			sr.Improvement = &improvement
+		}
+
+		ret = append(ret, sr)
+	}
+
+	return ret, nil
+}
+
+// bestOfSweep returns the lowest cost result, preferring fewer regions, or nil
+// if there are none.
+func bestOfSweep(sweep []SweepResult) *Result {
+	var best *Result
+	for i := range sweep {
+		if best == nil || sweep[i].Cost < best.Cost {
+			best = &sweep[i].Result
+		}
+	}
+	return best
+}
+
+// parseK parses the k parameter: a number of regions, or an inclusive range of
+// them to sweep.
+//
+//	k=4
+//	k=1-12
+func parseK(param string, nVertices int) (int, int, error) {
+	minParam, maxParam, isRange := strings.Cut(param, "-")
+	if !isRange {
+		maxParam = minParam
+	}
+
+	kMin, err := strconv.Atoi(minParam)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad k %q: %w", param, err)
+	}
+	kMax, err := strconv.Atoi(maxParam)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad k %q: %w", param, err)
+	}
+
+	if kMin < 1 || kMax > nVertices || kMin > kMax {
+		return 0, 0, fmt.Errorf("k must be in [1 %d]", nVertices)
+	}
+
+	return kMin, kMax, nil
 }
 
 func errJSON(w http.ResponseWriter, logMsg string, err error) bool {
@@ -175,97 +821,262 @@ func errJSON(w http.ResponseWriter, logMsg string, err error) bool {
 	return true
 }
 
-type promData map[string]int
+// parseObjective parses the objective parameter:
+//
+//	sum          - minimize average latency (default)
+//	max          - minimize the worst latency of any region with users
+//	max-weighted - minimize the worst traffic-weighted latency of any region
+//	bounded:80   - minimize average latency, with every region with users
+//	               served within 80ms
+func parseObjective(param string) ([]graph.Option, error) {
+	name, arg, hasArg := strings.Cut(param, ":")
 
-func readPromData(r io.Reader) (promData, error) {
-	var pdj promDataJson
-	if err := json.NewDecoder(r).Decode(&pdj); err != nil {
-		return nil, err
+	switch {
+	case name == "" || name == "sum":
+		return nil, nil
+	case name == "max" && !hasArg:
+		return []graph.Option{graph.WithObjective(graph.ObjectiveMax)}, nil
+	case name == "max-weighted" && !hasArg:
+		return []graph.Option{graph.WithObjective(graph.ObjectiveMaxWeighted)}, nil
+	case name == "bounded" && hasArg:
+		maxLatency, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad objective %q: %w", param, err)
+		}
+		if maxLatency < 0 {
+			return nil, fmt.Errorf("bad objective %q: negative latency", param)
+		}
+		return []graph.Option{graph.WithMaxCost(maxLatency)}, nil
+	default:
+		return nil, fmt.Errorf("unknown objective %q", param)
 	}
+}
 
-	pd := make(promData, len(pdj.Data.Result))
+// parseFailover parses the failover parameter, which makes the cost account
+// for any one region being down. Users of a region that's down are served by
+// their next nearest region. The cost with every region up is added to the
+// average (expected) or worst (worst) cost with one region down, multiplied by
+// an optional weight (default 1).
+//
+//	failover=expected
+//	failover=worst:0.5
+func parseFailover(param string) (graph.Option, error) {
+	name, arg, hasArg := strings.Cut(param, ":")
 
-	for _, res := range pdj.Data.Result {
-		if res.Metric.Region == "" {
-			logrus.Warn("bad prom data: no region")
-			continue
-		}
+	var failover graph.Failover
+	switch name {
+	case "expected":
+		failover = graph.FailoverExpected
+	case "worst":
+		failover = graph.FailoverWorst
+	default:
+		return nil, fmt.Errorf("unknown failover %q", param)
+	}
 
-		if l := len(res.Value); l != 2 {
-			logrus.Warnf("bad prom data: %d fields in value", l)
-			continue
+	weight := 1.0
+	if hasArg {
+		var err error
+		if weight, err = strconv.ParseFloat(arg, 64); err != nil {
+			return nil, fmt.Errorf("bad failover %q: %w", param, err)
 		}
-
-		sv, ok := res.Value[1].(string)
-		if !ok {
-			logrus.Warnf("bad prom data: %T val", sv)
-			continue
+		if !(weight >= 0) || math.IsInf(weight, 1) {
+			return nil, fmt.Errorf("bad failover %q: weight must be non-negative", param)
 		}
+	}
+
+	return graph.WithFailover(failover, weight), nil
+}
+
+// parseSinkCosts parses the budget and cost_weight parameters, which use the
+// region costs from the request body or cost parameters. budget is the most the chosen regions
+// can cost in total. cost_weight is how much each unit of cost counts against
+// a millisecond of latency in the result's cost.
+//
+//	budget=100
+//	cost_weight=0.5
+func parseSinkCosts(paramBudget, paramCostWeight string, rc regionCosts, regions []string) ([]graph.Option, error) {
+	if len(rc) == 0 {
+		return nil, errors.New("budget and cost_weight need region costs, from cost parameters or the request body")
+	}
 
-		iv, err := strconv.ParseInt(sv, 10, 64)
+	costs, err := rc.costs(regions)
+	if err != nil {
+		return nil, err
+	}
+	opts := []graph.Option{graph.WithSinkCosts(costs)}
+
+	if paramBudget != "" {
+		budget, err := strconv.ParseFloat(paramBudget, 64)
 		if err != nil {
-			logrus.WithError(err).Warn("bad prom data: parse val")
-			continue
+			return nil, fmt.Errorf("bad budget %q: %w", paramBudget, err)
+		}
+		if !(budget >= 0) {
+			return nil, fmt.Errorf("bad budget %q: must be non-negative", paramBudget)
 		}
+		opts = append(opts, graph.WithBudget(budget))
+	}
 
-		pd[res.Metric.Region] = int(iv)
+	if paramCostWeight != "" {
+		weight, err := strconv.ParseFloat(paramCostWeight, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad cost_weight %q: %w", paramCostWeight, err)
+		}
+		if !(weight >= 0) || math.IsInf(weight, 1) {
+			return nil, fmt.Errorf("bad cost_weight %q: must be non-negative", paramCostWeight)
+		}
+		opts = append(opts, graph.WithSinkCostWeight(weight))
 	}
 
-	return pd, nil
+	return opts, nil
 }
 
-func (pd promData) weights(regions []string) []float64 {
-	sum := 0
-	for _, r := range regions {
-		sum += pd[r]
+// parseRegionCosts parses cost parameters into the cost of each region, for
+// traffic formats that can't include them. Parameters are comma separated
+// lists of region:cost pairs, which override costs from the request body.
+//
+//	cost=iad:20,fra:25&cost=ord:18
+func parseRegionCosts(params []string, rc regionCosts) (regionCosts, error) {
+	ret := maps.Clone(rc)
+	if ret == nil {
+		ret = regionCosts{}
 	}
 
-	ret := make([]float64, len(regions))
-	if sum > 0 {
-		for i, r := range regions {
-			ret[i] = float64(pd[r]) / float64(sum)
+	for _, param := range params {
+		for _, field := range strings.Split(param, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+
+			region, cost, ok := strings.Cut(field, ":")
+			if !ok {
+				return nil, fmt.Errorf("bad cost %q: must be region:cost", field)
+			}
+
+			c, err := strconv.ParseFloat(cost, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad cost %q: %w", field, err)
+			}
+			ret[region] = c
 		}
 	}
 
-	return ret
+	return ret, nil
 }
 
-func (pd promData) unknownRegions(knownRegions []string) []string {
-	kr := make(map[string]bool, len(knownRegions))
-	for _, r := range knownRegions {
-		kr[r] = true
+// parseCapacities parses capacity parameters into the max share of traffic
+// each region can serve. Parameters are comma separated lists of region:share
+// pairs, or a bare share that applies to regions without their own.
+//
+//	capacity=0.3&capacity=iad:0.5,fra:0.1
+func parseCapacities(params []string, regions []string) ([]float64, error) {
+	var (
+		ret        = make([]float64, len(regions))
+		byRegion   = map[string]float64{}
+		defaultCap = 1.0
+	)
+
+	for _, param := range params {
+		for _, field := range strings.Split(param, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+
+			region, share, hasRegion := strings.Cut(field, ":")
+			if !hasRegion {
+				share = region
+			}
+
+			c, err := strconv.ParseFloat(share, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad capacity %q: %w", field, err)
+			}
+			if c < 0 || c > 1 {
+				return nil, fmt.Errorf("capacity %q must be in [0 1]", field)
+			}
+
+			if hasRegion {
+				if !slices.Contains(regions, region) {
+					return nil, fmt.Errorf("capacity for unknown region %q", region)
+				}
+				byRegion[region] = c
+			} else {
+				defaultCap = c
+			}
+		}
 	}
 
-	ret := []string{}
-	for r, _ := range pd {
-		if _, known := kr[r]; !known {
-			ret = append(ret, r)
+	for i, region := range regions {
+		if c, ok := byRegion[region]; ok {
+			ret[i] = c
+		} else {
+			ret[i] = defaultCap
 		}
 	}
 
-	return ret
+	return ret, nil
+}
+
+// maxAlternatives is the most combinations the alternatives parameter can ask
+// for.
+const maxAlternatives = 20
+
+// parseAlternatives parses the alternatives parameter: how many of the best
+// combinations to return, best first. It defaults to 1.
+func parseAlternatives(param string) (int, error) {
+	if param == "" {
+		return 1, nil
+	}
+
+	n, err := strconv.Atoi(param)
+	if err != nil {
+		return 0, fmt.Errorf("bad alternatives %q: %w", param, err)
+	}
+	if n < 1 || n > maxAlternatives {
+		return 0, fmt.Errorf("alternatives must be in [1 %d]", maxAlternatives)
+	}
+
+	return n, nil
 }
 
-// data from fly.io prometheus query:
+// parseRegionList parses comma separated lists of regions, checking that
+// they're all known.
 //
-//	query=sum(increase(fly_edge_http_responses_count)) by (region)
-type promDataJson struct {
-	Data struct {
-		Result []struct {
-			Metric struct {
-				Region string `json:"region"`
-			} `json:"metric"`
-			Value []any `json:"value"`
-		} `json:"result"`
-	} `json:"data"`
+//	require=iad,fra&require=ord
+func parseRegionList(params []string, regions []string) ([]string, error) {
+	var ret []string
+
+	for _, param := range params {
+		for _, region := range strings.Split(param, ",") {
+			region = strings.TrimSpace(region)
+			if region == "" {
+				continue
+			}
+			if !slices.Contains(regions, region) {
+				return nil, fmt.Errorf("unknown region %q", region)
+			}
+			ret = append(ret, region)
+		}
+	}
+
+	return ret, nil
 }
 
+// exactSolvers are the values of the SOLVER environment variable, which picks
+// the solver used for larger k. "bnb", the default, is the pure Go branch and
+// bound solver. "graph" uses lpsolve, unless built with the purego tag or
+// without cgo. lpsolve can't be interrupted, so with "graph" a solve runs past
+// exactTimeout and past the client going away rather than falling back to the
+// heuristic.
+var exactSolvers = []string{"graph", "bnb"}
+
 type model struct {
-	s    *regions.Server
-	g    *graph.Graph
-	bf   *graph.BruteForcer
-	m    sync.RWMutex
-	stop chan struct{}
+	s      *regions.Server
+	solver string
+	mesh   *mesh
+	m      sync.RWMutex
+	stop   chan struct{}
 }
 
 func (m *model) run() {
@@ -274,17 +1085,14 @@ func (m *model) run() {
 
 runLoop:
 	for {
-		regionNames, linkCosts := modelParams(m.s.Latencies())
-		g, err := graph.NewGraph(regionNames, linkCosts)
+		ms, err := newMesh(m.solver, m.s.Latencies(), jitters(m.s.LatenciesStats()))
 		if err != nil {
 			logrus.WithError(err).Warn("building graph")
 			continue runLoop
 		}
-		bf := graph.NewBruteForcer(regionNames, linkCosts)
 
 		m.m.Lock()
-		m.g = g
-		m.bf = bf
+		m.mesh = ms
 		m.m.Unlock()
 
 		select {
@@ -295,7 +1103,54 @@ runLoop:
 	}
 }
 
-func modelParams(latencies map[string]map[string]int) ([]string, [][]float64) {
+// mesh is the solvers for one set of latencies between regions.
+type mesh struct {
+	solver string
+	g      graph.Solver
+	h      *graph.HeuristicSolver
+	bf     *graph.BruteForcer
+
+	// imputed are the links whose costs were estimated.
+	imputed links
+
+	// jitter is the standard deviation of each link's cost. See
+	// jitterStdDevs.
+	jitter [][]float64
+}
+
+// newMesh builds solvers for latencies, using solver for larger k. See
+// exactSolvers. jitter is each link's measured jitter, if known.
+func newMesh(solver string, latencies, jitter map[string]map[string]int) (*mesh, error) {
+	regionNames, linkCosts, imputed := modelParams(latencies)
+
+	g, err := newSolver(solver, regionNames, linkCosts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mesh{
+		solver:  solver,
+		g:       g,
+		h:       graph.NewHeuristicSolver(regionNames, linkCosts),
+		bf:      graph.NewBruteForcer(regionNames, linkCosts),
+		imputed: imputed,
+		jitter:  jitterStdDevs(regionNames, jitter),
+	}, nil
+}
+
+func newSolver(solver string, regionNames []string, linkCosts [][]float64) (graph.Solver, error) {
+	if solver == "bnb" {
+		return graph.NewBranchAndBound(regionNames, linkCosts), nil
+	}
+	return graph.NewGraph(regionNames, linkCosts)
+}
+
+// modelParams returns the regions and the cost of users in each region being
+// served by each other region, as well as which links had no data and were
+// estimated. The cost is the latency measured from the users' region, or from
+// the serving region if that's all there is. Unreachable links, reported with
+// math.MaxInt latency, have no data.
+func modelParams(latencies map[string]map[string]int) ([]string, [][]float64, links) {
 	// collection list of regions from combination of all regions' data in case
 	// we're missing any locally
 	regionMap := make(map[string]bool, len(latencies))
@@ -309,26 +1164,34 @@ func modelParams(latencies map[string]map[string]int) ([]string, [][]float64) {
 	regions := maps.Keys(regionMap)
 	slices.Sort(regions)
 
-	linkCosts := make([][]float64, len(regions)-1)
-	for i := 1; i < len(regions); i++ {
-		for j := 0; j < i; j++ {
-			ij, haveIJ := latencies[regions[i]][regions[j]]
-			ji, haveJI := latencies[regions[j]][regions[i]]
+	linkCosts := make([][]float64, len(regions))
+	for source := range regions {
+		linkCosts[source] = make([]float64, len(regions))
+		for sink := range regions {
+			if sink == source {
+				continue
+			}
+			fromSource, haveFromSource := measured(latencies, regions[source], regions[sink])
+			fromSink, haveFromSink := measured(latencies, regions[sink], regions[source])
 			switch {
-			case haveIJ && haveJI:
-				linkCosts[i-1] = append(linkCosts[i-1], (float64(ij)+float64(ji))/2)
-			case haveIJ:
-				linkCosts[i-1] = append(linkCosts[i-1], float64(ij))
-			case haveJI:
-				linkCosts[i-1] = append(linkCosts[i-1], float64(ji))
+			case haveFromSource:
+				linkCosts[source][sink] = float64(fromSource)
+			case haveFromSink:
+				linkCosts[source][sink] = float64(fromSink)
 			default:
-				// no data about cost. assume it's expensive
-				linkCosts[i-1] = append(linkCosts[i-1], math.MaxFloat64)
+				// no data about cost. estimated below
+				linkCosts[source][sink] = math.Inf(1)
 			}
 		}
 	}
 
-	return regions, linkCosts
+	return regions, linkCosts, impute(regions, linkCosts)
+}
+
+// measured returns the latency from src to dst, if it was measured.
+func measured(latencies map[string]map[string]int, src, dst string) (int, bool) {
+	l, ok := latencies[src][dst]
+	return l, ok && l != math.MaxInt
 }
 
 var (
@@ -1,57 +1,363 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"math"
 	"testing"
 
 	"github.com/alecthomas/assert/v2"
+	regions "github.com/btoews/best-regions"
+	"github.com/btoews/best-regions/graph"
 )
 
-func TestDecodePromData(t *testing.T) {
-	const d = `{"status":"success","isPartial":false,"data":{"resultType":"vector","result":[{"metric":{"region":"ams"},"value":[1689867197,"21"]},{"metric":{"region":"arn"},"value":[1689867197,"20"]},{"metric":{"region":"atl"},"value":[1689867197,"4"]},{"metric":{"region":"bom"},"value":[1689867197,"12"]},{"metric":{"region":"cdg"},"value":[1689867197,"31"]},{"metric":{"region":"chi"},"value":[1689867197,"5"]},{"metric":{"region":"dfw"},"value":[1689867197,"32"]},{"metric":{"region":"fra"},"value":[1689867197,"85"]},{"metric":{"region":"gdl"},"value":[1689867197,"2"]},{"metric":{"region":"gru"},"value":[1689867197,"51"]},{"metric":{"region":"hkg"},"value":[1689867197,"33"]},{"metric":{"region":"iad"},"value":[1689867197,"19"]},{"metric":{"region":"jnb"},"value":[1689867197,"8"]},{"metric":{"region":"lax"},"value":[1689867197,"47"]},{"metric":{"region":"lga"},"value":[1689867197,"25"]},{"metric":{"region":"yyz"},"value":[1689867197,"26"]}]}}`
-	pd, err := readPromData(bytes.NewReader([]byte(d)))
-	assert.NoError(t, err)
-	assert.Equal(t, promData{
-		"ams": 21,
-		"arn": 20,
-		"atl": 4,
-		"bom": 12,
-		"cdg": 31,
-		"chi": 5,
-		"dfw": 32,
-		"fra": 85,
-		"gdl": 2,
-		"gru": 51,
-		"hkg": 33,
-		"iad": 19,
-		"jnb": 8,
-		"lax": 47,
-		"lga": 25,
-		"yyz": 26,
-	}, pd)
-}
-
 func TestModelParams(t *testing.T) {
-	vertices, edgeCosts := modelParams(map[string]map[string]int{
+	vertices, edgeCosts, imputed := modelParams(map[string]map[string]int{
 		"a": {"b": 2},
 		"b": {"a": 1},
 	})
 	assert.Equal(t, []string{"a", "b"}, vertices)
-	assert.Equal(t, [][]float64{{1.5}}, edgeCosts)
+	assert.Equal(t, [][]float64{{0, 2}, {1, 0}}, edgeCosts)
+	assert.Equal(t, links{}, imputed)
 
-	vertices, edgeCosts = modelParams(map[string]map[string]int{
+	// b-c is estimated via a
+	vertices, edgeCosts, imputed = modelParams(map[string]map[string]int{
 		"a": {"b": 2, "c": 3},
 		"b": {"a": 1},
 	})
 	assert.Equal(t, []string{"a", "b", "c"}, vertices)
-	assert.Equal(t, [][]float64{{1.5}, {3, math.MaxFloat64}}, edgeCosts)
+	assert.Equal(t, [][]float64{{0, 2, 3}, {1, 0, 4}, {3, 5, 0}}, edgeCosts)
+	assert.Equal(t, links{{"b", "c"}: true}, imputed)
 
-	vertices, edgeCosts = modelParams(map[string]map[string]int{
+	vertices, edgeCosts, imputed = modelParams(map[string]map[string]int{
 		"a": {"b": 2},
 		"b": {"a": 1},
 		"c": {"a": 3, "b": 4},
 	})
 	assert.Equal(t, []string{"a", "b", "c"}, vertices)
-	assert.Equal(t, [][]float64{{1.5}, {3, 4}}, edgeCosts)
+	assert.Equal(t, [][]float64{{0, 2, 3}, {1, 0, 4}, {3, 4, 0}}, edgeCosts)
+	assert.Equal(t, links{}, imputed)
+
+	// c is unreachable, so a-c is measured from c and b-c is estimated via a
+	vertices, edgeCosts, imputed = modelParams(map[string]map[string]int{
+		"a": {"b": 2, "c": math.MaxInt},
+		"b": {"a": 1, "c": math.MaxInt},
+		"c": {"a": 3},
+	})
+	assert.Equal(t, []string{"a", "b", "c"}, vertices)
+	assert.Equal(t, [][]float64{{0, 2, 3}, {1, 0, 4}, {3, 5, 0}}, edgeCosts)
+	assert.Equal(t, links{{"b", "c"}: true}, imputed)
+}
+
+func TestImpute(t *testing.T) {
+	inf := math.Inf(1)
+
+	// ams-fra goes via lhr and there's no path to iad, so it's estimated from
+	// distance at the same ms per km as the measured links
+	names := []string{"ams", "fra", "iad", "lhr"}
+	costs := [][]float64{
+		{0, inf, inf, 5},
+		{inf, 0, inf, 10},
+		{inf, inf, 0, inf},
+		{5, 10, inf, 0},
+	}
+	imputed := impute(names, costs)
+	assert.True(t, imputed.has("iad", "ams") && imputed.has("ams", "iad"))
+	assert.False(t, imputed.has("fra", "lhr"))
+	assert.Equal(t, 4, len(imputed))
+	assert.Equal(t, 15, costs[0][1])
+
+	dist := func(a, b string) float64 { return regions.Catalog[a].Distance(regions.Catalog[b].Location) }
+	msPerKm := 15 / (dist("ams", "lhr") + dist("fra", "lhr"))
+	assert.Equal(t, dist("ams", "iad")*msPerKm, costs[0][2])
+	assert.Equal(t, dist("iad", "lhr")*msPerKm, costs[2][3])
+
+	// regions that aren't in the catalog are as slow as the slowest link
+	names = []string{"a", "b", "c", "d"}
+	costs = [][]float64{
+		{0, inf, 10, inf},
+		{inf, 0, inf, 20},
+		{10, inf, 0, inf},
+		{inf, 20, inf, 0},
+	}
+	imputed = impute(names, costs)
+	assert.Equal(t, [][]float64{
+		{0, 20, 10, 20},
+		{20, 0, 20, 20},
+		{10, 20, 0, 20},
+		{20, 20, 20, 0},
+	}, costs)
+	assert.Equal(t, links{{"a", "b"}: true, {"b", "c"}: true, {"a", "d"}: true, {"c", "d"}: true}, imputed)
+
+	// nothing is known
+	costs = [][]float64{{0, inf}, {inf, 0}}
+	impute([]string{"a", "b"}, costs)
+	assert.Equal(t, [][]float64{{0, unknownLatency}, {unknownLatency, 0}}, costs)
+}
+
+func TestParseCapacities(t *testing.T) {
+	regions := []string{"fra", "iad", "lax"}
+
+	capacities, err := parseCapacities([]string{"iad:0.5, fra:0.1"}, regions)
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{0.1, 0.5, 1}, capacities)
+
+	capacities, err = parseCapacities([]string{"iad:0.5", "0.3"}, regions)
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{0.3, 0.5, 0.3}, capacities)
+
+	_, err = parseCapacities([]string{"ord:0.5"}, regions)
+	assert.Error(t, err)
+
+	_, err = parseCapacities([]string{"iad:1.5"}, regions)
+	assert.Error(t, err)
+
+	_, err = parseCapacities([]string{"iad:half"}, regions)
+	assert.Error(t, err)
+}
+
+func TestParseObjective(t *testing.T) {
+	for _, param := range []string{"", "sum", "max", "max-weighted", "bounded:80"} {
+		_, err := parseObjective(param)
+		assert.NoError(t, err, param)
+	}
+
+	for _, param := range []string{"min", "max:80", "bounded", "bounded:fast", "bounded:-1"} {
+		_, err := parseObjective(param)
+		assert.Error(t, err, param)
+	}
+}
+
+func TestParseK(t *testing.T) {
+	kMin, kMax, err := parseK("4", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, [2]int{4, 4}, [2]int{kMin, kMax})
+
+	kMin, kMax, err = parseK("1-10", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, [2]int{1, 10}, [2]int{kMin, kMax})
+
+	for _, param := range []string{"0", "11", "1-11", "5-4", "x", "1-x", "-"} {
+		_, _, err = parseK(param, 10)
+		assert.Error(t, err, param)
+	}
+}
+
+func TestParseFailover(t *testing.T) {
+	for _, param := range []string{"expected", "worst", "worst:0.5", "expected:0"} {
+		_, err := parseFailover(param)
+		assert.NoError(t, err, param)
+	}
+
+	for _, param := range []string{"", "best", "worst:", "worst:-1", "worst:NaN", "expected:x"} {
+		_, err := parseFailover(param)
+		assert.Error(t, err, param)
+	}
+}
+
+func TestParseSinkCosts(t *testing.T) {
+	regions := []string{"fra", "iad", "lax"}
+	rc := regionCosts{"iad": 10, "fra": 20}
+
+	costs, err := rc.costs(regions)
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{20, 10, 0}, costs)
+
+	opts, err := parseSinkCosts("25", "", rc, regions)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(opts))
+
+	opts, err = parseSinkCosts("25", "0.5", rc, regions)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(opts))
+
+	for _, params := range [][2]string{{"-1", ""}, {"x", ""}, {"", "-1"}, {"", "NaN"}} {
+		_, err = parseSinkCosts(params[0], params[1], rc, regions)
+		assert.Error(t, err, params)
+	}
+
+	_, err = parseSinkCosts("25", "", nil, regions)
+	assert.Error(t, err)
+
+	_, err = parseSinkCosts("25", "", regionCosts{"ord": 1}, regions)
+	assert.Error(t, err)
+
+	_, err = parseSinkCosts("25", "", regionCosts{"iad": -1}, regions)
+	assert.Error(t, err)
+
+	// cost parameters add to and override costs from the body
+	rc, err = parseRegionCosts([]string{"iad:15, lax:5", "lax:7"}, rc)
+	assert.NoError(t, err)
+	assert.Equal(t, regionCosts{"iad": 15, "fra": 20, "lax": 7}, rc)
+
+	rc, err = parseRegionCosts([]string{"iad:15"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, regionCosts{"iad": 15}, rc)
+
+	for _, param := range []string{"iad", "iad:x", "iad:"} {
+		_, err = parseRegionCosts([]string{param}, nil)
+		assert.Error(t, err, param)
+	}
+}
+
+func TestBestOfSweep(t *testing.T) {
+	assert.Zero(t, bestOfSweep(nil))
+
+	sweep := []SweepResult{
+		{K: 1, Result: Result{Regions: []string{"iad"}, Cost: 30}},
+		{K: 2, Result: Result{Regions: []string{"fra", "iad"}, Cost: 20}},
+		{K: 3, Result: Result{Regions: []string{"fra", "iad", "lax"}, Cost: 20}},
+	}
+	assert.Equal(t, &sweep[1].Result, bestOfSweep(sweep))
+}
+
+func TestParseMachines(t *testing.T) {
+	mc, err := parseMachines("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, machines{}, mc)
+
+	mc, err = parseMachines("10", "0.2")
+	assert.NoError(t, err)
+	assert.Equal(t, machines{count: 10, capacity: 0.2}, mc)
+
+	for _, params := range [][2]string{{"10", ""}, {"", "0.2"}, {"0", "0.2"}, {"x", "0.2"}, {"10", "0"}, {"10", "2"}, {"10", "x"}} {
+		_, err = parseMachines(params[0], params[1])
+		assert.Error(t, err, params)
+	}
+}
+
+func TestMachines(t *testing.T) {
+	bf := graph.NewBruteForcer([]string{"fra", "iad", "lax"}, [][]float64{{80}, {150, 60}})
+	weights := []float64{0.5, 0.3, 0.2}
+	mc := machines{count: 3, capacity: 0.4}
+	opts := []graph.Option{graph.WithMachines(mc.count, mc.capacity)}
+
+	// serving lax from iad is cheapest, but then fra and iad need 2 machines
+	// each. fra has to serve iad or lax to get by with 3.
+	cost, picks, err := bf.Solve(context.Background(), 2, weights, opts...)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"fra", "lax"}, picks)
+	assert.Equal(t, 24.0, cost)
+
+	result := Result{Regions: picks}
+	assert.NoError(t, mc.allocate(context.Background(), &result, bf, weights, opts))
+	assert.Equal(t, map[string]int{"fra": 2, "lax": 1}, result.Machines)
+
+	// fra needs 2 machines on its own
+	_, _, err = bf.Solve(context.Background(), 3, weights, opts...)
+	assert.IsError(t, err, graph.ErrInfeasible)
+}
+
+func TestParseAlternatives(t *testing.T) {
+	n, err := parseAlternatives("")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	n, err = parseAlternatives("3")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	for _, param := range []string{"0", "-1", "21", "x"} {
+		_, err = parseAlternatives(param)
+		assert.Error(t, err, param)
+	}
+}
+
+func TestParseRegionList(t *testing.T) {
+	regions := []string{"fra", "iad", "lax"}
+
+	list, err := parseRegionList([]string{"iad, fra", "lax,"}, regions)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"iad", "fra", "lax"}, list)
+
+	_, err = parseRegionList([]string{"iad,ord"}, regions)
+	assert.Error(t, err)
+}
+
+func TestDescribe(t *testing.T) {
+	bf := graph.NewBruteForcer([]string{"fra", "iad", "lax"}, [][]float64{{80}, {150, 60}})
+
+	result := Result{Regions: []string{"iad"}}
+	assert.NoError(t, describe(context.Background(), &result, bf, nil, []float64{0.5, 0.25, 0.25}, nil))
+	assert.Equal(t, map[string]Assignment{
+		"fra": {Region: "iad", Latency: 80, Weight: 0.5},
+		"iad": {Region: "iad", Latency: 0, Weight: 0.25},
+		"lax": {Region: "iad", Latency: 60, Weight: 0.25},
+	}, result.Assignments)
+	assert.Equal(t, 55.0, result.AverageLatency)
+	assert.Equal(t, []string{"fra", "lax", "iad"}, result.WorstServed)
+
+	// regions without users aren't described
+	result = Result{Regions: []string{"fra", "lax"}}
+	assert.NoError(t, describe(context.Background(), &result, bf, nil, []float64{0.5, 0, 0.5}, nil))
+	assert.Equal(t, map[string]Assignment{
+		"fra": {Region: "fra", Latency: 0, Weight: 0.5, Backup: "lax", BackupLatency: 150},
+		"lax": {Region: "lax", Latency: 0, Weight: 0.5, Backup: "fra", BackupLatency: 150},
+	}, result.Assignments)
+	assert.Equal(t, 0.0, result.AverageLatency)
+
+	// estimated latencies are flagged
+	result = Result{Regions: []string{"fra", "lax"}}
+	assert.NoError(t, describe(context.Background(), &result, bf, links{{"fra", "iad"}: true, {"fra", "lax"}: true}, []float64{0.5, 0.5, 0}, nil))
+	assert.Equal(t, map[string]Assignment{
+		"fra": {Region: "fra", Latency: 0, Weight: 0.5, Backup: "lax", BackupLatency: 150, BackupImputed: true},
+		"iad": {Region: "lax", Latency: 60, Weight: 0.5, Backup: "fra", BackupLatency: 80, BackupImputed: true},
+	}, result.Assignments)
+
+	result = Result{Regions: []string{"ord"}}
+	assert.Error(t, describe(context.Background(), &result, bf, nil, []float64{0.5, 0.25, 0.25}, nil))
+}
+
+func TestExplain(t *testing.T) {
+	bf := graph.NewBruteForcer([]string{"fra", "iad", "lax"}, [][]float64{{80}, {150, 60}})
+	weights := []float64{0.5, 0.25, 0.25}
+
+	result := Result{Regions: []string{"iad"}}
+	assert.NoError(t, explain(context.Background(), &result, bf, weights, nil, false))
+	assert.Zero(t, result.Explanation)
+
+	assert.NoError(t, explain(context.Background(), &result, bf, weights, nil, true))
+	fraDelta, laxDelta := 0.25*80+0.25*150-55, 0.5*150+0.25*60-55
+	assert.Equal(t, &Explanation{
+		Removal: map[string]*float64{"iad": nil},
+		Swaps: []Swap{
+			{Region: "fra", Replaces: "iad", Delta: &fraDelta},
+			{Region: "lax", Replaces: "iad", Delta: &laxDelta},
+		},
+	}, result.Explanation)
+
+	result = Result{Regions: []string{"ord"}}
+	assert.Error(t, explain(context.Background(), &result, bf, weights, nil, true))
+}
+
+func TestSolveSweep(t *testing.T) {
+	regions := []string{"ams", "fra", "iad", "lax", "ord"}
+	costs := [][]float64{{10}, {80, 85}, {150, 155, 60}, {100, 105, 20, 45}}
+	weights := []float64{0.2, 0.2, 0.2, 0.2, 0.2}
+
+	bf := graph.NewBruteForcer(regions, costs)
+	g := graph.NewBranchAndBound(regions, costs)
+	h := graph.NewHeuristicSolver(regions, costs)
+
+	// one region can't serve everyone
+	opts := []graph.Option{graph.WithCapacities([]float64{.6, .6, .6, .6, .6})}
+
+	sweep, err := solveSweep(context.Background(), "bnb", bf, g, h, 1, 5, weights, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(sweep))
+
+	for i, sr := range sweep {
+		assert.Equal(t, i+2, sr.K)
+
+		cost, picks, err := bf.Solve(context.Background(), sr.K, weights, opts...)
+		assert.NoError(t, err)
+		assert.Equal(t, picks, sr.Regions)
+		assert.Equal(t, cost, sr.Cost)
+
+		if i == 0 {
+			assert.Zero(t, sr.Improvement)
+		} else {
+			assert.Equal(t, sweep[i-1].Cost-sr.Cost, *sr.Improvement)
+		}
+	}
+	assert.Equal(t, 0.0, sweep[3].Cost)
 }
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"time"
+
+	regions "github.com/btoews/best-regions"
+)
+
+// solveBuckets are upper bounds in seconds for solve duration histograms.
+var solveBuckets = []float64{.001, .01, .1, .5, 1, 5, 10, 30, 60}
+
+var (
+	solvers        = []string{"bf", "graph", "bnb", "heuristic"}
+	solveDurations = map[string]*regions.Histogram{}
+)
+
+func init() {
+	for _, solver := range solvers {
+		solveDurations[solver] = regions.NewHistogram(solveBuckets...)
+	}
+}
+
+func timeSolve(solver string, start time.Time) {
+	solveDurations[solver].Observe(time.Since(start).Seconds())
+}
+
+func writeSolveMetrics(mw *regions.MetricsWriter) {
+	mw.Family("solve_duration_seconds", "histogram", "Time taken to find optimal regions, by solver.")
+	for _, solver := range solvers {
+		mw.Histogram("solve_duration_seconds", solveDurations[solver], "solver", solver)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	regions "github.com/btoews/best-regions"
+	"github.com/btoews/best-regions/graph"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// maxTrials is the most trials the trials parameter can ask for.
+	maxTrials = 1000
+
+	// sensitivityTimeout is how long trials are solved for. Trials that aren't
+	// solved by then are left out.
+	sensitivityTimeout = 30 * time.Second
+)
+
+// Sensitivity is how robust a result is to noise in traffic and latencies.
+type Sensitivity struct {
+	// Trials is how many perturbed problems were solved, of which Infeasible
+	// couldn't satisfy the constraints.
+	Trials     int `json:"trials"`
+	Infeasible int `json:"infeasible,omitempty"`
+
+	// Picked is the fraction of feasible trials that picked each region.
+	Picked map[string]float64 `json:"picked"`
+
+	// SameAsResult is the fraction of feasible trials that picked the same
+	// regions as the first result.
+	SameAsResult float64 `json:"same_as_result"`
+
+	// Cost is the distribution of costs of feasible trials.
+	Cost *Distribution `json:"cost,omitempty"`
+}
+
+// Distribution summarizes a sample.
+type Distribution struct {
+	Min  float64 `json:"min"`
+	P10  float64 `json:"p10"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	Max  float64 `json:"max"`
+	Mean float64 `json:"mean"`
+}
+
+// parseSensitivity parses the sensitivity parameters. It returns false if
+// trials isn't given.
+//
+//	trials       - how many perturbed problems to solve, up to 1000
+//	weight_noise - how much to vary traffic, e.g. 0.2 for ±20% (default 0)
+//	cost_noise   - how much to vary latencies, e.g. 0.1 for ±10% (default 0)
+//	jitter=true  - also vary latencies by their measured jitter
+//	seed         - seeds the noise, so trials can be reproduced (default 0)
+func parseSensitivity(q url.Values, jitter [][]float64) (graph.MonteCarlo, bool, error) {
+	paramTrials := q.Get("trials")
+	if paramTrials == "" {
+		return graph.MonteCarlo{}, false, nil
+	}
+
+	trials, err := strconv.Atoi(paramTrials)
+	if err != nil {
+		return graph.MonteCarlo{}, false, fmt.Errorf("bad trials %q: %w", paramTrials, err)
+	}
+	if trials < 1 || trials > maxTrials {
+		return graph.MonteCarlo{}, false, fmt.Errorf("trials must be in [1 %d]", maxTrials)
+	}
+
+	mc := graph.MonteCarlo{Trials: trials}
+
+	for _, noise := range []struct {
+		name string
+		v    *float64
+	}{{"weight_noise", &mc.WeightNoise}, {"cost_noise", &mc.CostNoise}} {
+		param := q.Get(noise.name)
+		if param == "" {
+			continue
+		}
+		if *noise.v, err = strconv.ParseFloat(param, 64); err != nil || !(*noise.v >= 0 && *noise.v <= 1) {
+			return graph.MonteCarlo{}, false, fmt.Errorf("%s must be in [0 1], not %q", noise.name, param)
+		}
+	}
+
+	if paramSeed := q.Get("seed"); paramSeed != "" {
+		if mc.Seed, err = strconv.ParseInt(paramSeed, 10, 64); err != nil {
+			return graph.MonteCarlo{}, false, fmt.Errorf("bad seed %q: %w", paramSeed, err)
+		}
+	}
+
+	if q.Get("jitter") == "true" {
+		mc.CostStdDevs = jitter
+	}
+
+	return mc, true, nil
+}
+
+// sensitivity solves the trials in mc for k regions and summarizes them. base
+// is the result they're compared with.
+func sensitivity(ctx context.Context, ms *mesh, mc graph.MonteCarlo, k int, weights []float64, opts []graph.Option, base []string) (*Sensitivity, error) {
+	ctx, cancel := context.WithTimeout(ctx, sensitivityTimeout)
+	defer cancel()
+
+	// each trial gets a solver of its own. like other queries, small k are
+	// brute forced. larger k use BranchAndBound whatever the mesh's solver is,
+	// since lpsolve can't be stopped at sensitivityTimeout.
+	trialSolver := func(vertices []string, edgeCosts [][]float64) (graph.Solver, error) {
+		if k < 4 {
+			return graph.NewBruteForcer(vertices, edgeCosts), nil
+		}
+		return graph.NewBranchAndBound(vertices, edgeCosts), nil
+	}
+
+	solutions, err := mc.Run(ctx, trialSolver, ms.bf.Vertices, ms.bf.EdgeCosts, k, weights, opts...)
+	if err != nil && !(errors.Is(err, graph.ErrIncomplete) && len(solutions) != 0) {
+		return nil, err
+	}
+
+	ret := &Sensitivity{Trials: len(solutions), Picked: map[string]float64{}}
+
+	var costs []float64
+	for _, sol := range solutions {
+		if sol.Picks == nil {
+			ret.Infeasible++
+			continue
+		}
+		costs = append(costs, sol.Cost)
+		for _, region := range sol.Picks {
+			ret.Picked[region]++
+		}
+		if slices.Equal(sol.Picks, base) {
+			ret.SameAsResult++
+		}
+	}
+
+	if feasible := float64(len(costs)); feasible != 0 {
+		for region := range ret.Picked {
+			ret.Picked[region] /= feasible
+		}
+		ret.SameAsResult /= feasible
+		ret.Cost = distribution(costs)
+	}
+
+	return ret, nil
+}
+
+// distribution summarizes a non-empty sample.
+func distribution(sample []float64) *Distribution {
+	sorted := slices.Clone(sample)
+	sort.Float64s(sorted)
+
+	// nearest-rank percentiles
+	percentile := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(sorted)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return sorted[i]
+	}
+
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+
+	return &Distribution{
+		Min:  sorted[0],
+		P10:  percentile(.1),
+		P50:  percentile(.5),
+		P90:  percentile(.9),
+		Max:  sorted[len(sorted)-1],
+		Mean: sum / float64(len(sorted)),
+	}
+}
+
+// jitterStdDevs returns the standard deviation of the cost of each link, in
+// the shape of modelParams' link costs, from regions' measured jitter. Jitter
+// is the mean difference between consecutive samples, which is 2/√π standard
+// deviations for normally distributed latencies. Links without jitter don't
+// vary.
+func jitterStdDevs(regionNames []string, jitter map[string]map[string]int) [][]float64 {
+	ret := make([][]float64, len(regionNames))
+	for source := range regionNames {
+		ret[source] = make([]float64, len(regionNames))
+		for sink := range regionNames {
+			if sink == source {
+				continue
+			}
+			j, ok := jitter[regionNames[source]][regionNames[sink]]
+			if !ok {
+				j = jitter[regionNames[sink]][regionNames[source]]
+			}
+			ret[source][sink] = float64(j) * math.Sqrt(math.Pi) / 2
+		}
+	}
+	return ret
+}
+
+// jitters returns the jitter of each link from latency stats.
+func jitters(stats map[string]map[string]regions.LatencyStats) map[string]map[string]int {
+	ret := make(map[string]map[string]int, len(stats))
+	for src, dsts := range stats {
+		ret[src] = make(map[string]int, len(dsts))
+		for dst, s := range dsts {
+			ret[src][dst] = s.Jitter
+		}
+	}
+	return ret
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/url"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/btoews/best-regions/graph"
+)
+
+func TestParseSensitivity(t *testing.T) {
+	jitter := [][]float64{{0, 1}, {1, 0}}
+
+	_, ok, err := parseSensitivity(url.Values{}, jitter)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	mc, ok, err := parseSensitivity(url.Values{"trials": {"50"}}, jitter)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, graph.MonteCarlo{Trials: 50}, mc)
+
+	mc, ok, err = parseSensitivity(url.Values{
+		"trials":       {"50"},
+		"weight_noise": {"0.2"},
+		"cost_noise":   {"0.1"},
+		"jitter":       {"true"},
+		"seed":         {"7"},
+	}, jitter)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, graph.MonteCarlo{Trials: 50, WeightNoise: 0.2, CostNoise: 0.1, CostStdDevs: jitter, Seed: 7}, mc)
+
+	for _, q := range []url.Values{
+		{"trials": {"0"}},
+		{"trials": {"1001"}},
+		{"trials": {"x"}},
+		{"trials": {"5"}, "weight_noise": {"1.5"}},
+		{"trials": {"5"}, "cost_noise": {"-0.1"}},
+		{"trials": {"5"}, "seed": {"x"}},
+	} {
+		_, _, err := parseSensitivity(q, jitter)
+		assert.Error(t, err, "%v", q)
+	}
+}
+
+func TestSensitivity(t *testing.T) {
+	ms, err := newMesh("bnb", map[string]map[string]int{
+		"ams": {"fra": 10, "iad": 80},
+		"fra": {"iad": 85},
+	}, nil)
+	assert.NoError(t, err)
+	weights := []float64{0.5, 0.3, 0.2}
+	ctx := context.Background()
+
+	// without noise, every trial picks the same regions
+	s, err := sensitivity(ctx, ms, graph.MonteCarlo{Trials: 10}, 1, weights, nil, []string{"ams"})
+	assert.NoError(t, err)
+	assert.Equal(t, &Sensitivity{
+		Trials:       10,
+		Picked:       map[string]float64{"ams": 1},
+		SameAsResult: 1,
+		Cost:         &Distribution{Min: 19, P10: 19, P50: 19, P90: 19, Max: 19, Mean: 19},
+	}, s)
+
+	s, err = sensitivity(ctx, ms, graph.MonteCarlo{Trials: 100, WeightNoise: 1, Seed: 1}, 1, weights, nil, []string{"ams"})
+	assert.NoError(t, err)
+	assert.Equal(t, 100, s.Trials)
+	assert.True(t, s.SameAsResult > 0 && s.SameAsResult < 1, "%v", s.SameAsResult)
+	assert.Equal(t, s.SameAsResult, s.Picked["ams"])
+	assert.True(t, s.Cost.Min <= s.Cost.P50 && s.Cost.P50 <= s.Cost.Max)
+
+	// every trial is infeasible
+	s, err = sensitivity(ctx, ms, graph.MonteCarlo{Trials: 3}, 1, weights, []graph.Option{graph.WithRequired("ams", "fra")}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &Sensitivity{Trials: 3, Infeasible: 3, Picked: map[string]float64{}}, s)
+}
+
+func TestDistribution(t *testing.T) {
+	assert.Equal(t, &Distribution{Min: 1, P10: 1, P50: 5, P90: 9, Max: 10, Mean: 5.5},
+		distribution([]float64{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}))
+	assert.Equal(t, &Distribution{Min: 3, P10: 3, P50: 3, P90: 3, Max: 3, Mean: 3}, distribution([]float64{3}))
+}
+
+func TestJitterStdDevs(t *testing.T) {
+	stdDevs := jitterStdDevs([]string{"a", "b", "c"}, map[string]map[string]int{
+		"a": {"b": 2},
+		"c": {"a": 4},
+	})
+	sd := func(jitter float64) float64 { return jitter * math.Sqrt(math.Pi) / 2 }
+	assert.Equal(t, [][]float64{
+		{0, sd(2), sd(4)},
+		{sd(2), 0, 0},
+		{sd(4), 0, 0},
+	}, stdDevs)
+}
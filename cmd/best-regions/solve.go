@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	regions "github.com/btoews/best-regions"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+const solveUsage = `usage: best-regions solve -latencies FILE -traffic FILE [flags]
+
+Picks regions offline, from saved latencies (the shape of /latencies.json, v1
+or v2) and a traffic file (prometheus data, json or csv, chosen by -format or
+the file extension). Flags named after query parameters of the POST endpoint
+take the same values.
+
+`
+
+// queryParams are the query parameters of the POST endpoint that solve takes
+// as flags. Those that can be repeated are true.
+var queryParams = map[string]bool{
+	"k":                false,
+	"sweep":            false,
+	"alternatives":     false,
+	"objective":        false,
+	"capacity":         true,
+	"machines":         false,
+	"machine_capacity": false,
+	"failover":         false,
+	"cost":             true,
+	"budget":           false,
+	"cost_weight":      false,
+	"require":          true,
+	"exclude":          true,
+	"compare":          true,
+	"explain":          false,
+	"trials":           false,
+	"weight_noise":     false,
+	"cost_noise":       false,
+	"jitter":           false,
+	"seed":             false,
+}
+
+// queryFlag sets a query parameter from a flag.
+type queryFlag struct {
+	q      url.Values
+	name   string
+	repeat bool
+}
+
+func (f queryFlag) String() string {
+	if f.q == nil {
+		return ""
+	}
+	return strings.Join(f.q[f.name], " ")
+}
+
+func (f queryFlag) Set(v string) error {
+	if f.repeat {
+		f.q.Add(f.name, v)
+	} else {
+		f.q.Set(f.name, v)
+	}
+	return nil
+}
+
+// solveCommand runs the solve subcommand, returning the exit code.
+func solveCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("solve", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, solveUsage)
+		fs.PrintDefaults()
+	}
+
+	var (
+		latenciesPath = fs.String("latencies", "", "latencies `file`")
+		trafficPath   = fs.String("traffic", "", "traffic `file`, or - for stdin")
+		format        = fs.String("format", "", "traffic format: prometheus, json or csv")
+		solver        = fs.String("solver", "bnb", "solver for larger k: "+strings.Join(exactSolvers, " or "))
+		output        = fs.String("output", "table", "output format: table or json")
+		q             = url.Values{}
+	)
+	for name, repeat := range queryParams {
+		usage := "see the " + name + " query parameter"
+		if repeat {
+			usage += " (repeatable)"
+		}
+		fs.Var(queryFlag{q, name, repeat}, name, usage)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	switch {
+	case *latenciesPath == "" || *trafficPath == "":
+		fmt.Fprintln(stderr, "-latencies and -traffic are required")
+		return 2
+	case !slices.Contains(exactSolvers, *solver):
+		fmt.Fprintf(stderr, "unknown solver %q\n", *solver)
+		return 2
+	case *output != "table" && *output != "json":
+		fmt.Fprintf(stderr, "unknown output %q\n", *output)
+		return 2
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	results, err := solveFiles(ctx, *latenciesPath, *trafficPath, *format, *solver, q)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	if results.Error != "" {
+		fmt.Fprintln(stderr, "warning:", results.Error)
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(results)
+	} else {
+		err = writeTable(stdout, results)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// solveFiles answers query q for the latencies and traffic in files.
+func solveFiles(ctx context.Context, latenciesPath, trafficPath, format, solver string, q url.Values) (Results, error) {
+	latencies, jitter, err := readLatencies(latenciesPath)
+	if err != nil {
+		return Results{}, err
+	}
+
+	tr, rc, err := readTrafficFile(trafficPath, format)
+	if err != nil {
+		return Results{}, err
+	}
+
+	ms, err := newMesh(solver, latencies, jitter)
+	if err != nil {
+		return Results{}, fmt.Errorf("building graph: %w", err)
+	}
+
+	return ms.query(ctx, tr, rc, q)
+}
+
+// readLatencies reads latencies saved from /latencies.json. The v2 schema's
+// moving averages are used, the same as v1, and its jitter is returned too.
+// Unreachable links keep their math.MaxInt latency, which modelParams treats as
+// no data.
+func readLatencies(path string) (latencies, jitter map[string]map[string]int, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, nil, fmt.Errorf("bad latencies: %w", err)
+	}
+
+	if _, isV2 := fields["version"]; !isV2 {
+		if err := json.Unmarshal(data, &latencies); err != nil {
+			return nil, nil, fmt.Errorf("bad latencies: %w", err)
+		}
+		return latencies, nil, nil
+	}
+
+	var v2 regions.LatenciesReport
+	if err := json.Unmarshal(data, &v2); err != nil {
+		return nil, nil, fmt.Errorf("bad latencies: %w", err)
+	}
+	if v2.Version != regions.SchemaV2 {
+		return nil, nil, fmt.Errorf("bad latencies: unknown version %d", v2.Version)
+	}
+
+	latencies = make(map[string]map[string]int, len(v2.Latencies))
+	for src, stats := range v2.Latencies {
+		latencies[src] = make(map[string]int, len(stats))
+		for dst, s := range stats {
+			latencies[src][dst] = s.SMA
+		}
+	}
+	return latencies, jitters(v2.Latencies), nil
+}
+
+// readTrafficFile reads traffic from path, or stdin if it's "-". Without a
+// format, it's chosen by the file extension.
+func readTrafficFile(path, format string) (traffic, regionCosts, error) {
+	if format == "" {
+		switch filepath.Ext(path) {
+		case ".csv":
+			format = formatCSV
+		case ".json":
+			format = formatJSON
+		default:
+			format = formatPrometheus
+		}
+	}
+
+	if path == "-" {
+		return readTrafficFormat(format, os.Stdin)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	return readTrafficFormat(format, f)
+}
+
+// writeTable writes results as a table, one row per result.
+func writeTable(w io.Writer, results Results) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "K\tREGIONS\tCOST\tAVG LATENCY\tWORST SERVED\tNOTES")
+
+	row := func(k int, r Result, notes []string) {
+		if r.Approximate {
+			notes = append(notes, fmt.Sprintf("approximate (gap %.1f%%)", 100*r.Gap))
+		}
+		if n := imputedAssignments(r); n != 0 {
+			notes = append(notes, fmt.Sprintf("%d imputed latencies", n))
+		}
+		if len(r.Machines) != 0 {
+			machines := make([]string, 0, len(r.Machines))
+			for region, count := range r.Machines {
+				machines = append(machines, fmt.Sprintf("%s:%d", region, count))
+			}
+			sort.Strings(machines)
+			notes = append(notes, "machines "+strings.Join(machines, ","))
+		}
+
+		fmt.Fprintf(tw, "%d\t%s\t%.2f\t%.1fms\t%s\t%s\n",
+			k, strings.Join(r.Regions, ","), r.Cost, r.AverageLatency,
+			strings.Join(r.WorstServed, ","), strings.Join(notes, "; "))
+	}
+
+	for _, r := range results.Results {
+		row(len(r.Regions), r, nil)
+	}
+	for _, sr := range results.Sweep {
+		var notes []string
+		if sr.Improvement != nil {
+			notes = append(notes, fmt.Sprintf("improves %.2f", *sr.Improvement))
+		}
+		row(sr.K, sr.Result, notes)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if s := results.Sensitivity; s != nil {
+		picked := maps.Keys(s.Picked)
+		sort.Slice(picked, func(i, j int) bool {
+			a, b := picked[i], picked[j]
+			return s.Picked[a] > s.Picked[b] || (s.Picked[a] == s.Picked[b] && a < b)
+		})
+		for i, region := range picked {
+			picked[i] = fmt.Sprintf("%s %.0f%%", region, 100*s.Picked[region])
+		}
+
+		fmt.Fprintf(w, "\n%d trials, %d infeasible, %.0f%% same as the first result\n", s.Trials, s.Infeasible, 100*s.SameAsResult)
+		fmt.Fprintf(w, "picked: %s\n", strings.Join(picked, ", "))
+		if c := s.Cost; c != nil {
+			fmt.Fprintf(w, "cost: min %.2f, p10 %.2f, p50 %.2f, p90 %.2f, max %.2f\n", c.Min, c.P10, c.P50, c.P90, c.Max)
+		}
+	}
+
+	return nil
+}
+
+// imputedAssignments counts the assignments in r with estimated latencies.
+func imputedAssignments(r Result) int {
+	var n int
+	for _, a := range r.Assignments {
+		if a.Imputed {
+			n++
+		}
+	}
+	return n
+}
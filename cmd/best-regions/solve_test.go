@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestSolveCommand(t *testing.T) {
+	dir := t.TempDir()
+	latenciesPath := filepath.Join(dir, "latencies.json")
+	trafficPath := filepath.Join(dir, "traffic.csv")
+
+	assert.NoError(t, os.WriteFile(latenciesPath, []byte(`{
+		"ams": {"fra": 8, "iad": 80},
+		"fra": {"ams": 9, "iad": 85},
+		"iad": {"ams": 81, "fra": 86}
+	}`), 0o644))
+	assert.NoError(t, os.WriteFile(trafficPath, []byte("ams,10\nfra,20\niad,30\n"), 0o644))
+
+	var stdout, stderr bytes.Buffer
+	code := solveCommand([]string{
+		"-latencies", latenciesPath,
+		"-traffic", trafficPath,
+		"-solver", "bnb",
+		"-k", "2",
+		"-exclude", "iad",
+		"-compare", "ams,iad",
+		"-output", "json",
+	}, &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+
+	var results Results
+	assert.NoError(t, json.Unmarshal(stdout.Bytes(), &results))
+	assert.Equal(t, 2, len(results.Results))
+	assert.Equal(t, []string{"ams", "fra"}, results.Results[0].Regions)
+	assert.Equal(t, []string{"ams", "iad"}, results.Results[1].Regions)
+	assert.Equal(t, 81*30.0/60, results.Results[0].Cost)
+
+	stdout.Reset()
+	code = solveCommand([]string{"-latencies", latenciesPath, "-traffic", trafficPath, "-k", "1-2"}, &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	assert.Equal(t, 3, len(lines))
+	assert.True(t, strings.HasPrefix(lines[0], "K  REGIONS"))
+	assert.True(t, strings.HasPrefix(lines[2], "2  fra,iad"), lines[2])
+
+	// csv traffic can't include costs, so they're flags
+	stdout.Reset()
+	code = solveCommand([]string{"-latencies", latenciesPath, "-traffic", trafficPath, "-solver", "bnb", "-k", "1", "-cost", "iad:100,ams:10,fra:10", "-budget", "50", "-output", "json"}, &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	results = Results{}
+	assert.NoError(t, json.Unmarshal(stdout.Bytes(), &results))
+	assert.Equal(t, []string{"ams"}, results.Results[0].Regions)
+	assert.Equal(t, 1, solveCommand([]string{"-latencies", latenciesPath, "-traffic", trafficPath, "-k", "1", "-budget", "50"}, &stdout, &stderr))
+
+	assert.Equal(t, 2, solveCommand([]string{"-traffic", trafficPath}, &stdout, &stderr))
+	assert.Equal(t, 2, solveCommand([]string{"-latencies", latenciesPath, "-traffic", trafficPath, "-solver", "magic"}, &stdout, &stderr))
+	assert.Equal(t, 1, solveCommand([]string{"-latencies", latenciesPath, "-traffic", trafficPath, "-k", "x"}, &stdout, &stderr))
+	assert.Equal(t, 1, solveCommand([]string{"-latencies", filepath.Join(dir, "missing.json"), "-traffic", trafficPath}, &stdout, &stderr))
+}
+
+func TestReadLatencies(t *testing.T) {
+	dir := t.TempDir()
+	v1Path := filepath.Join(dir, "v1.json")
+	v2Path := filepath.Join(dir, "v2.json")
+
+	assert.NoError(t, os.WriteFile(v1Path, []byte(`{"ams": {"fra": 8}, "fra": {"ams": 9}}`), 0o644))
+	assert.NoError(t, os.WriteFile(v2Path, []byte(`{
+		"version": 2,
+		"latencies": {
+			"ams": {"fra": {"sma": 8, "p99": 20, "jitter": 2}},
+			"fra": {"ams": {"sma": 9, "p99": 30, "jitter": 3}}
+		}
+	}`), 0o644))
+
+	expected := map[string]map[string]int{"ams": {"fra": 8}, "fra": {"ams": 9}}
+
+	latencies, jitter, err := readLatencies(v1Path)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, latencies)
+	assert.Zero(t, jitter)
+
+	latencies, jitter, err = readLatencies(v2Path)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, latencies)
+	assert.Equal(t, map[string]map[string]int{"ams": {"fra": 2}, "fra": {"ams": 3}}, jitter)
+
+	assert.NoError(t, os.WriteFile(v2Path, []byte(`{"version": 3, "latencies": {}}`), 0o644))
+	_, _, err = readLatencies(v2Path)
+	assert.Error(t, err)
+}
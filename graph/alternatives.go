@@ -0,0 +1,103 @@
+package graph
+
+import (
+	"container/heap"
+	"context"
+
+	"golang.org/x/exp/slices"
+)
+
+// Solution is a combination of sinks and its cost.
+type Solution struct {
+	Cost  float64
+	Picks []string
+}
+
+// AlternativesSolver is a Solver that can also find the next best
+// combinations of sinks.
+type AlternativesSolver interface {
+	Solver
+
+	// SolveN returns up to n distinct combinations of k sinks, best first. The
+	// first is the combination Solve would return. Fewer are returned if fewer
+	// combinations satisfy the constraints. If ctx is done before the solver
+	// finishes, the best combinations found so far are returned with an error
+	// wrapping ErrIncomplete and ctx's error.
+	SolveN(ctx context.Context, k, n int, vertexWeights []float64, opts ...Option) ([]Solution, error)
+}
+
+// topN keeps the n best combinations it's offered. Combinations with equal
+// scores rank in the order they were offered.
+type topN struct {
+	n    int
+	seq  int
+	heap rankedHeap
+}
+
+type ranked struct {
+	combo []int
+	score score
+	seq   int
+}
+
+func newTopN(n int) *topN {
+	return &topN{n: n, heap: make(rankedHeap, 0, n)}
+}
+
+// offer keeps a copy of combo if it's among the n best so far.
+func (t *topN) offer(combo []int, cs score) {
+	t.seq++
+	switch {
+	case len(t.heap) < t.n:
+		heap.Push(&t.heap, ranked{slices.Clone(combo), cs, t.seq})
+	case cs.less(t.heap[0].score):
+		t.heap[0] = ranked{slices.Clone(combo), cs, t.seq}
+		heap.Fix(&t.heap, 0)
+	}
+}
+
+func (t *topN) full() bool {
+	return len(t.heap) == t.n
+}
+
+func (t *topN) empty() bool {
+	return len(t.heap) == 0
+}
+
+// worst returns the score of the worst combination kept.
+func (t *topN) worst() score {
+	return t.heap[0].score
+}
+
+// solutions returns the kept combinations, best first.
+func (t *topN) solutions(vertices []string) []Solution {
+	sorted := slices.Clone(t.heap)
+	slices.SortFunc(sorted, func(a, b ranked) bool {
+		return rankedBefore(a, b)
+	})
+
+	ret := make([]Solution, len(sorted))
+	for i, r := range sorted {
+		ret[i] = Solution{Cost: r.score.cost, Picks: sortedNames(vertices, r.combo)}
+	}
+	return ret
+}
+
+func rankedBefore(a, b ranked) bool {
+	return a.score.less(b.score) || (!b.score.less(a.score) && a.seq < b.seq)
+}
+
+// rankedHeap is a max-heap, with the worst combination on top.
+type rankedHeap []ranked
+
+func (h rankedHeap) Len() int           { return len(h) }
+func (h rankedHeap) Less(i, j int) bool { return rankedBefore(h[j], h[i]) }
+func (h rankedHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *rankedHeap) Push(x any)        { *h = append(*h, x.(ranked)) }
+
+func (h *rankedHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,449 @@
+package graph
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"golang.org/x/exp/slices"
+)
+
+// Subgradient iterations used to tighten the Lagrangian bound at the root of
+// the search and at each node below it. Nodes start from their parent's
+// multipliers, so they need far fewer.
+const (
+	rootIterations = 100
+	nodeIterations = 5
+)
+
+// BranchAndBound is a pure Go Solver that doesn't need lpsolve. It decides
+// whether each vertex is a sink in turn, depth-first, and prunes branches
+// using a Lagrangian relaxation of the p-median problem. Complete combinations
+// are scored the same way as BruteForcer, so every Option is supported and
+// ties are broken the same way.
+type BranchAndBound struct {
+	Vertices  []string
+	EdgeCosts [][]float64
+	bf        *BruteForcer
+}
+
+var _ AlternativesSolver = (*BranchAndBound)(nil)
+
+func NewBranchAndBound(vertices []string, edgeCosts [][]float64) *BranchAndBound {
+	return &BranchAndBound{
+		Vertices:  vertices,
+		EdgeCosts: edgeCosts,
+		bf:        NewBruteForcer(vertices, edgeCosts),
+	}
+}
+
+func (g *BranchAndBound) Solve(ctx context.Context, k int, vertexWeights []float64, opts ...Option) (float64, []string, error) {
+	return first(g.SolveN(ctx, k, 1, vertexWeights, opts...))
+}
+
+// SolveN searches for the n best combinations, pruning branches that can't
+// beat the nth best found so far.
+func (g *BranchAndBound) SolveN(ctx context.Context, k, n int, vertexWeights []float64, opts ...Option) ([]Solution, error) {
+	o := newOptions(opts)
+
+	required, excluded, err := o.vertexSets(g.Vertices)
+	if err != nil {
+		return nil, err
+	}
+
+	if !o.enoughCapacity(k, vertexWeights) {
+		return nil, ErrInfeasible
+	}
+
+	s := newBnbSearch(g.bf, o, k, vertexWeights, required, excluded)
+	s.ctx = ctx
+	s.best = newTopN(n)
+
+	var lambda []float64
+	if o.objective == ObjectiveSum {
+		lambda = s.initLambda()
+	}
+	s.branch(0, lambda, rootIterations)
+
+	switch {
+	case s.stopped && s.best.empty():
+		return nil, ctx.Err()
+	case s.stopped:
+		return s.best.solutions(g.Vertices), incomplete(ctx)
+	case s.best.empty():
+		return nil, ErrInfeasible
+	}
+
+	return s.best.solutions(g.Vertices), nil
+}
+
+func newBnbSearch(bf *BruteForcer, o *options, k int, vertexWeights []float64, required, excluded []bool) *bnbSearch {
+	n := len(bf.Vertices)
+
+	s := &bnbSearch{
+		bf:        bf,
+		o:         o,
+		k:         k,
+		weights:   vertexWeights,
+		ec:        fullMatrix(n, bf.EdgeCosts),
+		wec:       bf.weightedEdgeCosts(vertexWeights),
+		required:  required,
+		excluded:  excluded,
+		reqSuffix: make([]int, n+1),
+		avlSuffix: make([]int, n+1),
+		combo:     make([]int, 0, k),
+		best:      newTopN(1),
+	}
+
+	for v := n - 1; v >= 0; v-- {
+		s.reqSuffix[v] = s.reqSuffix[v+1]
+		s.avlSuffix[v] = s.avlSuffix[v+1]
+		if required[v] {
+			s.reqSuffix[v]++
+		}
+		if !excluded[v] {
+			s.avlSuffix[v]++
+		}
+	}
+
+	return s
+}
+
+type bnbSearch struct {
+	// search stops early once ctx is done.
+	ctx     context.Context
+	stopped bool
+
+	bf      *BruteForcer
+	o       *options
+	k       int
+	weights []float64
+	ec, wec [][]float64
+
+	required, excluded []bool
+
+	// number of required and non-excluded vertices from each index onwards.
+	reqSuffix, avlSuffix []int
+
+	// sinks chosen so far, in index order.
+	combo []int
+
+	// upper bound on the optimal cost, for sizing subgradient steps.
+	target float64
+
+	best *topN
+}
+
+// branch searches combinations that extend s.combo with vertices from v
+// onwards. Vertices are included before they're excluded, so combinations are
+// visited in the same order as combinationEnumerator.
+func (s *bnbSearch) branch(v int, lambda []float64, iterations int) {
+	if s.stopped || s.ctx.Err() != nil {
+		s.stopped = true
+		return
+	}
+
+	need := s.k - len(s.combo)
+	if need < s.reqSuffix[v] || need > s.avlSuffix[v] {
+		return
+	}
+
+	if need == 0 {
+		cs, ok := s.bf.comboScore(s.ctx, s.ec, s.wec, s.combo, s.weights, s.o)
+		switch {
+		case ok:
+			s.best.offer(s.combo, cs)
+		case s.ctx.Err() != nil:
+			s.stopped = true
+		}
+		return
+	}
+
+	bound, ok := s.simpleBound(v)
+	if !ok {
+		return
+	}
+	if lambda != nil {
+		lambda = slices.Clone(lambda)
+		bound.cost = math.Max(bound.cost, s.lagrangianBound(v, lambda, iterations))
+	}
+	if s.best.full() && s.worse(bound) {
+		return
+	}
+
+	if !s.excluded[v] {
+		s.combo = append(s.combo, v)
+		s.branch(v+1, lambda, nodeIterations)
+		s.combo = s.combo[:len(s.combo)-1]
+	}
+
+	if !s.required[v] {
+		s.branch(v+1, lambda, nodeIterations)
+	}
+}
+
+// rootBound returns a lower bound on the cost of any combination, or +Inf if
+// there can't be one.
+func (s *bnbSearch) rootBound() float64 {
+	if s.k < s.reqSuffix[0] || s.k > s.avlSuffix[0] {
+		return math.Inf(1)
+	}
+
+	bound, ok := s.simpleBound(0)
+	if !ok {
+		return math.Inf(1)
+	}
+	if s.o.objective == ObjectiveSum {
+		bound.cost = math.Max(bound.cost, s.lagrangianBound(0, s.initLambda(), rootIterations))
+	}
+
+	return bound.cost
+}
+
+// worse returns true if no combination scoring at least bound can beat the
+// worst of the best combinations found so far.
+func (s *bnbSearch) worse(bound score) bool {
+	worst := s.best.worst()
+	tolerance := 1e-9 * math.Max(1, math.Abs(worst.cost))
+	if bound.cost > worst.cost+tolerance {
+		return true
+	}
+	return s.o.objective != ObjectiveSum && bound.cost >= worst.cost && bound.total > worst.total+tolerance
+}
+
+// candidates calls fn for every vertex that may be a sink below the node for
+// v: those already chosen and those from v onwards that aren't excluded.
+func (s *bnbSearch) candidates(v int, fn func(sink int)) {
+	for _, sink := range s.combo {
+		fn(sink)
+	}
+	for sink := v; sink < len(s.excluded); sink++ {
+		if !s.excluded[sink] {
+			fn(sink)
+		}
+	}
+}
+
+// simpleBound scores serving every vertex from its nearest candidate sink, as
+// though they were all chosen, plus the cheapest sinks that could complete the
+// combination. It returns false if a vertex with weight has no candidate
+// within the max cost or the cheapest sinks are over budget.
+func (s *bnbSearch) simpleBound(v int) (score, bool) {
+	sinkCost, ok := s.sinkCostBound(v)
+	if !ok {
+		return score{}, false
+	}
+
+	var bound score
+	for source := range s.weights {
+		nearest := -1
+		s.candidates(v, func(sink int) {
+			if nearest < 0 || s.ec[source][sink] < s.ec[source][nearest] {
+				nearest = sink
+			}
+		})
+		if s.weights[source] > 0 && s.ec[source][nearest] > s.o.maxCost {
+			return score{}, false
+		}
+		bound = bound.add(s.o, s.ec[source][nearest], s.wec[source][nearest], s.weights[source])
+	}
+	return bound.plus(s.o, s.o.sinkCostWeight*sinkCost), true
+}
+
+// sinkCostBound returns the least total sink cost of any combination below the
+// node for v, and false if that's over budget.
+func (s *bnbSearch) sinkCostBound(v int) (float64, bool) {
+	if s.o.sinkCosts == nil {
+		return 0, true
+	}
+
+	sinkCost := s.o.sinkCost(s.combo)
+	need := s.k - len(s.combo)
+
+	var optional []float64
+	for sink := v; sink < len(s.excluded); sink++ {
+		switch {
+		case s.required[sink]:
+			sinkCost += s.o.vertexSinkCost(sink)
+			need--
+		case !s.excluded[sink]:
+			optional = append(optional, s.o.vertexSinkCost(sink))
+		}
+	}
+	if need > len(optional) {
+		need = len(optional)
+	}
+	if need > 0 {
+		sort.Float64s(optional)
+		sinkCost += sum(optional[:need])
+	}
+
+	return sinkCost, s.o.withinBudget(sinkCost)
+}
+
+// initLambda returns starting multipliers for the Lagrangian relaxation: each
+// vertex's cost of being served by its nearest other vertex. It also sets the
+// target for subgradient steps from a greedy solution.
+func (s *bnbSearch) initLambda() []float64 {
+	n := len(s.weights)
+	lambda := make([]float64, n)
+	for source := range lambda {
+		lambda[source] = math.Inf(1)
+		for sink := 0; sink < n; sink++ {
+			if sink != source && !s.excluded[sink] {
+				lambda[source] = math.Min(lambda[source], s.wec[source][sink])
+			}
+		}
+		if math.IsInf(lambda[source], 1) {
+			lambda[source] = 0
+		}
+	}
+
+	s.target = s.greedyCost()
+
+	return lambda
+}
+
+// greedyCost returns the total weighted cost of choosing the required sinks,
+// then repeatedly the sink that reduces the cost the most, plus the weighted
+// cost of the chosen sinks.
+func (s *bnbSearch) greedyCost() float64 {
+	n := len(s.weights)
+	nearest := make([]float64, n)
+	for i := range nearest {
+		nearest[i] = math.Inf(1)
+	}
+	open := func(sink int) {
+		for source := range nearest {
+			nearest[source] = math.Min(nearest[source], s.wec[source][sink])
+		}
+	}
+
+	chosen := make([]bool, n)
+	nChosen := 0
+	for v, req := range s.required {
+		if req {
+			open(v)
+			chosen[v] = true
+			nChosen++
+		}
+	}
+
+	for ; nChosen < s.k; nChosen++ {
+		best, bestCost := -1, math.Inf(1)
+		for sink := 0; sink < n; sink++ {
+			if chosen[sink] || s.excluded[sink] {
+				continue
+			}
+			var cost float64
+			for source := range nearest {
+				cost += math.Min(nearest[source], s.wec[source][sink])
+			}
+			if best < 0 || cost < bestCost {
+				best, bestCost = sink, cost
+			}
+		}
+		if best < 0 {
+			return math.Inf(1)
+		}
+		open(best)
+		chosen[best] = true
+	}
+
+	var sinkCost float64
+	for v, c := range chosen {
+		if c {
+			sinkCost += s.o.vertexSinkCost(v)
+		}
+	}
+
+	return sum(nearest) + s.o.sinkCostWeight*sinkCost
+}
+
+// lagrangianBound relaxes the constraint that each vertex is served exactly
+// once, penalizing violations with lambda. For fixed lambda, the best sinks are
+// those whose served vertices save the most relative to their penalty, net of
+// their weighted sink cost. The budget isn't accounted for. lambda
+// is improved in place with iterations subgradient steps and the best bound
+// found is returned.
+func (s *bnbSearch) lagrangianBound(v int, lambda []float64, iterations int) float64 {
+	n := len(s.weights)
+
+	var (
+		best     = math.Inf(-1)
+		bestL    = slices.Clone(lambda)
+		step     = 2.0
+		stalled  = 0
+		rho      = make([]float64, n)
+		open     = make([]int, 0, s.k)
+		optional = make([]int, 0, n)
+		subgrad  = make([]float64, n)
+	)
+
+	for it := 0; it < iterations; it++ {
+		open = append(open[:0], s.combo...)
+		optional = optional[:0]
+		s.candidates(v, func(sink int) {
+			rho[sink] = s.o.sinkCostWeight * s.o.vertexSinkCost(sink)
+			for source := 0; source < n; source++ {
+				if c := s.wec[source][sink] - lambda[source]; c < 0 {
+					rho[sink] += c
+				}
+			}
+			switch {
+			case sink < v:
+			case s.required[sink]:
+				open = append(open, sink)
+			default:
+				optional = append(optional, sink)
+			}
+		})
+		sort.Slice(optional, func(i, j int) bool { return rho[optional[i]] < rho[optional[j]] })
+		open = append(open, optional[:s.k-len(open)]...)
+
+		bound := sum(lambda)
+		for _, sink := range open {
+			bound += rho[sink]
+		}
+		if bound > best {
+			best = bound
+			copy(bestL, lambda)
+			stalled = 0
+		} else if stalled++; stalled > 5 {
+			step /= 2
+			stalled = 0
+		}
+
+		if math.IsInf(s.target, 0) || math.IsNaN(bound) {
+			break
+		}
+
+		// each vertex should be served by exactly one open sink
+		var norm float64
+		for source := range subgrad {
+			subgrad[source] = 1
+			for _, sink := range open {
+				if s.wec[source][sink] < lambda[source] {
+					subgrad[source]--
+				}
+			}
+			norm += subgrad[source] * subgrad[source]
+		}
+		if norm == 0 {
+			break
+		}
+
+		target := s.target
+		if s.best.full() && s.best.worst().cost < target {
+			target = s.best.worst().cost
+		}
+		gap := math.Max(target-bound, 1e-9*math.Max(1, math.Abs(bound)))
+		for source := range lambda {
+			lambda[source] += step * gap / norm * subgrad[source]
+		}
+	}
+
+	copy(lambda, bestL)
+
+	return best
+}
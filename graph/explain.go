@@ -0,0 +1,94 @@
+package graph
+
+import (
+	"context"
+	"math"
+)
+
+// Contribution is how much a sink contributes to a combination.
+type Contribution struct {
+	Sink string
+
+	// Removal is how much the cost increases without Sink. It's +Inf if the
+	// other sinks are infeasible.
+	Removal float64
+}
+
+// Swap is the best swap of a combination's sinks for a vertex that isn't one.
+type Swap struct {
+	Vertex string
+
+	// Sink is swapped out for Vertex, changing the cost by Delta. Sink is empty
+	// and Delta is +Inf if no swap is feasible.
+	Sink  string
+	Delta float64
+}
+
+// Explain returns why the sinks in combo were chosen: how much each sink
+// contributes, in the order of combo, and the best swap for every other vertex,
+// in the order of Vertices. Ties between swaps go to the earlier sink in combo.
+// Required and excluded vertex options are ignored, so what they cost shows
+// too. ctx's error is returned if it's done before every combination is scored.
+func (g *BruteForcer) Explain(ctx context.Context, combo []string, vertexWeights []float64, opts ...Option) ([]Contribution, []Swap, error) {
+	icombo, err := g.indices(combo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	o := newOptions(opts)
+	ec := fullMatrix(len(g.Vertices), g.EdgeCosts)
+	wec := g.weightedEdgeCosts(vertexWeights)
+
+	cost := func(c []int) float64 {
+		if len(c) == 0 {
+			return math.Inf(1)
+		}
+		if cs, ok := g.comboScore(ctx, ec, wec, c, vertexWeights, o); ok {
+			return cs.cost
+		}
+		return math.Inf(1)
+	}
+
+	base := cost(icombo)
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if math.IsInf(base, 1) {
+		return nil, nil, ErrInfeasible
+	}
+
+	inCombo := make(map[int]bool, len(icombo))
+	for _, sink := range icombo {
+		inCombo[sink] = true
+	}
+
+	contributions := make([]Contribution, len(icombo))
+	without := make([]int, 0, len(icombo))
+	for i, sink := range icombo {
+		without = append(append(without[:0], icombo[:i]...), icombo[i+1:]...)
+		contributions[i] = Contribution{Sink: g.Vertices[sink], Removal: cost(without) - base}
+	}
+
+	var swaps []Swap
+	swapped := make([]int, len(icombo))
+	for v := range g.Vertices {
+		if inCombo[v] {
+			continue
+		}
+
+		swap := Swap{Vertex: g.Vertices[v], Delta: math.Inf(1)}
+		for i, sink := range icombo {
+			copy(swapped, icombo)
+			swapped[i] = v
+			if delta := cost(swapped) - base; delta < swap.Delta {
+				swap.Sink, swap.Delta = g.Vertices[sink], delta
+			}
+		}
+		swaps = append(swaps, swap)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return contributions, swaps, nil
+}
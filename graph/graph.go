@@ -1,238 +1,555 @@
 package graph
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math"
+	"sort"
 	"sync"
 
-	"github.com/btoews/golp"
 	"golang.org/x/exp/slices"
 )
 
 type Solver interface {
-	Solve(k int, vertexWeights []float64) (cost float64, picks []string, err error)
+	// Solve picks k sinks. If ctx is done before the solver finishes, the best
+	// sinks found so far are returned with an error wrapping ErrIncomplete and
+	// ctx's error. If none were found, only ctx's error is returned.
+	Solve(ctx context.Context, k int, vertexWeights []float64, opts ...Option) (cost float64, picks []string, err error)
 }
 
-type Graph struct {
+var (
+	// ErrInfeasible is returned when no choice of sinks satisfies the
+	// constraints.
+	ErrInfeasible = errors.New("no feasible solution")
+
+	// ErrIncomplete is returned along with the best sinks found so far when
+	// solving stops early.
+	ErrIncomplete = errors.New("solving stopped early")
+)
+
+// ctxCheckInterval is how many combinations BruteForcer scores between
+// checking whether its context is done.
+const ctxCheckInterval = 1024
+
+func incomplete(ctx context.Context) error {
+	return fmt.Errorf("%w: %w", ErrIncomplete, ctx.Err())
+}
+
+// sortedNames returns the names of the vertices in combo, sorted.
+func sortedNames(vertices []string, combo []int) []string {
+	ret := make([]string, len(combo))
+	for i, v := range combo {
+		ret[i] = vertices[v]
+	}
+	slices.Sort(ret)
+	return ret
+}
+
+type BruteForcer struct {
 	Vertices  []string
 	EdgeCosts [][]float64
-	lp        *golp.LP
+	vmap      map[string]int
 }
 
-var _ Solver = (*Graph)(nil)
-
-// Create a new graph with named vertices and edge costs. Edge costs are
-// symmetrical, so only half the matrix is specified. For example, the cells
-// marked x should be specified for a graph with 3 vertices.
-//
-//	  |A|B|C|
-//	A | | | |
-//	B |x| | |
-//	C |x|x| |
-func NewGraph(vertices []string, edgeCosts [][]float64) (*Graph, error) {
-	g := &Graph{Vertices: vertices, EdgeCosts: edgeCosts}
-	if err := g.initLP(); err != nil {
-		return nil, err
+func NewBruteForcer(vertices []string, edgeCosts [][]float64) *BruteForcer {
+	vmap := make(map[string]int, len(vertices))
+	for i, v := range vertices {
+		vmap[v] = i
 	}
+	return &BruteForcer{vertices, edgeCosts, vmap}
+}
+
+var _ AlternativesSolver = (*BruteForcer)(nil)
 
-	return g, nil
+func (g *BruteForcer) Solve(ctx context.Context, k int, vertexWeights []float64, opts ...Option) (float64, []string, error) {
+	return first(g.SolveN(ctx, k, 1, vertexWeights, opts...))
 }
 
-func (g *Graph) Solve(k int, vertexWeights []float64) (float64, []string, error) {
-	nVertices := len(g.Vertices)
-	nEdges := nVertices * (nVertices - 1) / 2
-	nCols := nVertices + nEdges*2
+// SolveN scores every combination, keeping the n best in a bounded heap.
+func (g *BruteForcer) SolveN(ctx context.Context, k, n int, vertexWeights []float64, opts ...Option) ([]Solution, error) {
+	o := newOptions(opts)
+	ec := fullMatrix(len(g.Vertices), g.EdgeCosts)
+	wec := g.weightedEdgeCosts(vertexWeights)
 
-	lp := g.lp.Copy()
+	required, excluded, err := o.vertexSets(g.Vertices)
+	if err != nil {
+		return nil, err
+	}
 
-	// constraint: must choose k sinks
-	//   A+B+C=k
-	row := make([]golp.Entry, 0, nVertices)
-	for sink := 0; sink < nVertices; sink++ {
-		row = append(row, g.entry(sink))
+	// only enumerate combinations of vertices that aren't required or
+	// excluded. required vertices are in every combination.
+	var pinned, free []int
+	for v := range g.Vertices {
+		switch {
+		case required[v]:
+			pinned = append(pinned, v)
+		case !excluded[v]:
+			free = append(free, v)
+		}
 	}
-	if err := lp.AddConstraintSparse(row, golp.EQ, float64(k)); err != nil {
-		return 0, nil, err
+	if len(pinned) > k || !o.enoughCapacity(k, vertexWeights) {
+		return nil, ErrInfeasible
 	}
 
-	objRow := make([]float64, nCols)
-	for ri, row := range g.EdgeCosts {
-		a := ri + 1
-		for b, cost := range row {
-			objRow[g.edge(a, b)] = cost * vertexWeights[a]
-			objRow[g.edge(b, a)] = cost * vertexWeights[b]
+	var (
+		combo = append(make([]int, 0, k), pinned...)[:k]
+		best  = newTopN(n)
+	)
+
+	combos := newCombinationEnumerator(len(free), k-len(pinned))
+	defer combos.stop()
+
+	// scoring a capacitated combination is a search of its own, so ctx is
+	// checked before each one
+	for c := 0; combos.next(); c++ {
+		if (c%ctxCheckInterval == 0 || o.capacitated()) && ctx.Err() != nil {
+			return stoppedEarly(ctx, best, g.Vertices)
+		}
+
+		for i, f := range combos.State {
+			combo[len(pinned)+i] = free[f]
+		}
+
+		cs, ok := g.comboScore(ctx, ec, wec, combo, vertexWeights, o)
+		switch {
+		case ok:
+			best.offer(combo, cs)
+		case ctx.Err() != nil:
+			// the combination's assignment search gave up
+			return stoppedEarly(ctx, best, g.Vertices)
 		}
 	}
-	lp.SetObjFn(objRow)
+	if best.empty() {
+		return nil, ErrInfeasible
+	}
+
+	return best.solutions(g.Vertices), nil
+}
+
+// stoppedEarly returns the solutions found before ctx was done.
+func stoppedEarly(ctx context.Context, best *topN, vertices []string) ([]Solution, error) {
+	if best.empty() {
+		return nil, ctx.Err()
+	}
+	return best.solutions(vertices), incomplete(ctx)
+}
 
-	if st := lp.Solve(); st != golp.OPTIMAL {
-		return 0, nil, fmt.Errorf("%s solution", st)
+// first adapts SolveN with n=1 to Solve.
+func first(solutions []Solution, err error) (float64, []string, error) {
+	if len(solutions) == 0 {
+		return 0, nil, err
 	}
+	return solutions[0].Cost, solutions[0].Picks, err
+}
 
-	vars := lp.Variables()
-	ret := make([]string, 0, k)
-	for i, vertex := range g.Vertices {
-		if vars[i] != 0 {
-			ret = append(ret, vertex)
+func (g *BruteForcer) weightedEdgeCosts(vertexWeights []float64) [][]float64 {
+	wec := fullMatrix(len(g.Vertices), g.EdgeCosts)
+	for source, row := range wec {
+		for sink := range row {
+			row[sink] *= vertexWeights[source]
 		}
 	}
-	slices.Sort(ret)
+	return wec
+}
+
+// CombinationCost returns the cost of choosing combo as sinks. Required and
+// excluded vertex options are ignored. With capacities, finding the best
+// assignment is a search, so ctx's error is returned if it's done first.
+func (g *BruteForcer) CombinationCost(ctx context.Context, combo []string, vertexWeights []float64, opts ...Option) (float64, error) {
+	icombo, err := g.indices(combo)
+	if err != nil {
+		return 0, err
+	}
+
+	ec := fullMatrix(len(g.Vertices), g.EdgeCosts)
+	wec := g.weightedEdgeCosts(vertexWeights)
+
+	cs, ok := g.comboScore(ctx, ec, wec, icombo, vertexWeights, newOptions(opts))
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	} else if !ok {
+		return 0, ErrInfeasible
+	}
 
-	return lp.Objective(), ret, nil
+	return cs.cost, nil
 }
 
-func (g *Graph) initLP() error {
-	nVertices := len(g.Vertices)
-	nEdges := nVertices * (nVertices - 1) / 2
-	nCols := nVertices + nEdges*2
+// Assignment is how a vertex is served by a combination of sinks.
+type Assignment struct {
+	Vertex string
+	Sink   string
+
+	// Cost is the unweighted cost of serving Vertex from Sink.
+	Cost   float64
+	Weight float64
 
-	lp := golp.NewLP(0, nCols)
+	// Backup is the nearest other sink, which serves Vertex if Sink fails, and
+	// BackupCost is the unweighted cost of it doing so. Backup is empty if
+	// there's only one sink.
+	Backup     string
+	BackupCost float64
+}
 
-	for c := 0; c < nCols; c++ {
-		lp.SetBinary(c, true)
+// Assign returns how each vertex is served by the sinks in combo, in the order
+// of Vertices. Vertices are served by their nearest sink, unless capacities
+// require otherwise, and backed up by their nearest other sink. Required and
+// excluded vertex options are ignored. ctx's error is returned if it's done
+// before a capacitated assignment is found.
+func (g *BruteForcer) Assign(ctx context.Context, combo []string, vertexWeights []float64, opts ...Option) ([]Assignment, error) {
+	icombo, err := g.indices(combo)
+	if err != nil {
+		return nil, err
+	}
+	if len(icombo) == 0 {
+		return nil, ErrInfeasible
 	}
 
-	for source := 0; source < nVertices; source++ {
-		lp.SetColName(source, g.Vertices[source])
-		sourceOrSink := append(make([]golp.Entry, 0, nVertices), g.entry(source))
+	ec := fullMatrix(len(g.Vertices), g.EdgeCosts)
+	wec := g.weightedEdgeCosts(vertexWeights)
 
-		for sink := 0; sink < nVertices; sink++ {
-			if source == sink {
-				continue
-			}
+	sinks, ok := g.comboAssignment(ctx, ec, wec, icombo, vertexWeights, newOptions(opts))
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrInfeasible
+	}
 
-			lp.SetColName(g.edge(source, sink), g.Vertices[source]+"_"+g.Vertices[sink])
-			sourceOrSink = append(sourceOrSink, g.entry(source, sink))
+	var backups []int
+	if len(icombo) > 1 {
+		backups = backupSinks(ec, icombo, sinks)
+	}
 
-			// O(n^2) constraints: only sinks have incoming edges
-			//   A - BA >= 0
-			//   A - CA >= 0
-			lp.AddConstraintSparse([]golp.Entry{
-				g.entry(sink),
-				g.entryVal(-1, source, sink),
-			}, golp.GE, 0)
+	ret := make([]Assignment, len(g.Vertices))
+	for source, sink := range sinks {
+		ret[source] = Assignment{
+			Vertex: g.Vertices[source],
+			Sink:   g.Vertices[sink],
+			Cost:   ec[source][sink],
+			Weight: vertexWeights[source],
+		}
+		if backups != nil {
+			ret[source].Backup = g.Vertices[backups[source]]
+			ret[source].BackupCost = ec[source][backups[source]]
 		}
+	}
+
+	return ret, nil
+}
 
-		// O(n) constraints: each vertex must have 1 sink or be a source
-		//   A+AB+AC = 1
-		if err := lp.AddConstraintSparse(sourceOrSink, golp.EQ, 1); err != nil {
-			return err
+func (g *BruteForcer) indices(combo []string) ([]int, error) {
+	icombo := make([]int, 0, len(combo))
+	for _, c := range combo {
+		i, ok := g.vmap[c]
+		if !ok {
+			return nil, fmt.Errorf("unknown vertex %q", c)
 		}
+		icombo = append(icombo, i)
 	}
+	return icombo, nil
+}
 
-	g.lp = lp
+// score ranks combinations. cost is the objective. total is the total weighted
+// cost, which breaks ties for minimax objectives.
+type score struct {
+	cost, total float64
+}
 
-	return nil
+func (s score) less(o score) bool {
+	return s.cost < o.cost || (s.cost == o.cost && s.total < o.total)
 }
 
-func (g *Graph) entry(source int, sink ...int) golp.Entry {
-	return g.entryVal(1, source, sink...)
+// add accounts for a vertex being served at cost (weighted cost wcost).
+func (s score) add(o *options, cost, wcost, weight float64) score {
+	if o.objective == ObjectiveSum {
+		return score{cost: s.cost + wcost}
+	}
+	return score{cost: math.Max(s.cost, o.assignmentCost(cost, weight)), total: s.total + wcost}
 }
 
-func (g *Graph) entryVal(val float64, source int, sink ...int) golp.Entry {
-	switch len(sink) {
-	case 0:
-		return golp.Entry{Col: source, Val: val}
-	case 1:
-		return golp.Entry{Col: g.edge(source, sink[0]), Val: val}
-	default:
-		panic("bad entry call")
+// comboScore scores combo under the objective and constraints from options.
+// It returns false if the combination doesn't satisfy the constraints, or if
+// ctx is done before a capacitated assignment is found.
+func (g *BruteForcer) comboScore(ctx context.Context, ec, wec [][]float64, combo []int, vertexWeights []float64, o *options) (score, bool) {
+	sinkCost := o.sinkCost(combo)
+	if !o.withinBudget(sinkCost) {
+		return score{}, false
+	}
+
+	cs, ok := g.serviceScore(ctx, ec, wec, combo, vertexWeights, o)
+	if !ok {
+		return score{}, false
 	}
+
+	return cs.plus(o, o.sinkCostWeight*sinkCost), true
 }
 
-func (g *Graph) edge(source, sink int) int {
-	if source == sink {
-		panic("source=sink")
+// serviceScore scores serving vertices from the sinks in combo, without the
+// cost of the sinks themselves.
+func (g *BruteForcer) serviceScore(ctx context.Context, ec, wec [][]float64, combo []int, vertexWeights []float64, o *options) (score, bool) {
+	if o.failover != FailoverNone {
+		sinks, ok := g.comboAssignment(ctx, ec, wec, combo, vertexWeights, o)
+		if !ok || len(combo) < 2 {
+			return score{}, false
+		}
+		return failoverScore(ec, wec, combo, sinks, vertexWeights, o), true
 	}
 
-	nVertices := len(g.Vertices)
+	if o.capacitated() {
+		cs, _, ok := g.capacitatedAssignment(ctx, ec, wec, combo, vertexWeights, o)
+		return cs, ok
+	}
 
-	i := nVertices
-	i += source * (nVertices - 1)
-	if sink > source {
-		i += sink - 1
-	} else {
-		i += sink
+	if o.objective == ObjectiveSum && math.IsInf(o.maxCost, 1) {
+		return score{cost: g.comboCost(wec, combo)}, true
 	}
 
-	return i
-}
+	var cs score
+	for source := range g.Vertices {
+		nearest := combo[0]
+		for _, sink := range combo[1:] {
+			if ec[source][sink] < ec[source][nearest] {
+				nearest = sink
+			}
+		}
+		if vertexWeights[source] > 0 && ec[source][nearest] > o.maxCost {
+			return score{}, false
+		}
+		cs = cs.add(o, ec[source][nearest], wec[source][nearest], vertexWeights[source])
+	}
 
-type lp struct {
-	lp   *golp.LP
-	k    int
-	kRow int
+	return cs, true
 }
 
-type BruteForcer struct {
-	Vertices  []string
-	EdgeCosts [][]float64
-	vmap      map[string]int
+// failoverScore scores vertices being served by sinks, plus the failover cost
+// of each sink in combo failing in turn.
+func failoverScore(ec, wec [][]float64, combo, sinks []int, vertexWeights []float64, o *options) score {
+	backups := backupSinks(ec, combo, sinks)
+
+	var up score
+	for source, sink := range sinks {
+		up = up.add(o, ec[source][sink], wec[source][sink], vertexWeights[source])
+	}
+
+	// sinks fail in index order, so the score doesn't depend on combo's order.
+	var failover score
+	for failed := range sinks {
+		if !slices.Contains(combo, failed) {
+			continue
+		}
+
+		var cs score
+		for source, sink := range sinks {
+			if sink == failed {
+				sink = backups[source]
+			}
+			cs = cs.add(o, ec[source][sink], wec[source][sink], vertexWeights[source])
+		}
+
+		switch o.failover {
+		case FailoverExpected:
+			failover.cost += cs.cost / float64(len(combo))
+			failover.total += cs.total / float64(len(combo))
+		case FailoverWorst:
+			if failover.less(cs) {
+				failover = cs
+			}
+		}
+	}
+
+	return score{
+		cost:  up.cost + o.failoverWeight*failover.cost,
+		total: up.total + o.failoverWeight*failover.total,
+	}
 }
 
-func NewBruteForcer(vertices []string, edgeCosts [][]float64) *BruteForcer {
-	vmap := make(map[string]int, len(vertices))
-	for i, v := range vertices {
-		vmap[v] = i
+// backupSinks returns the nearest sink in combo to each vertex, other than the
+// one serving it. Ties go to the sink with the lowest index. combo must have at
+// least 2 sinks.
+func backupSinks(ec [][]float64, combo, sinks []int) []int {
+	backups := make([]int, len(sinks))
+	for source, sink := range sinks {
+		best := -1
+		for _, backup := range combo {
+			switch {
+			case backup == sink:
+			case best < 0, ec[source][backup] < ec[source][best]:
+				best = backup
+			case ec[source][backup] == ec[source][best] && backup < best:
+				best = backup
+			}
+		}
+		backups[source] = best
 	}
-	return &BruteForcer{vertices, edgeCosts, vmap}
+	return backups
 }
 
-var _ Solver = (*BruteForcer)(nil)
+// comboAssignment returns the sink serving each vertex in combo's best
+// assignment. It returns false if the combination doesn't satisfy the
+// constraints.
+func (g *BruteForcer) comboAssignment(ctx context.Context, ec, wec [][]float64, combo []int, vertexWeights []float64, o *options) ([]int, bool) {
+	if o.capacitated() {
+		_, sinks, ok := g.capacitatedAssignment(ctx, ec, wec, combo, vertexWeights, o)
+		return sinks, ok
+	}
 
-func (g *BruteForcer) Solve(k int, vertexWeights []float64) (float64, []string, error) {
-	wec := g.weightedEdgeCosts(vertexWeights)
+	sinks := make([]int, len(g.Vertices))
+	for source := range g.Vertices {
+		nearest := combo[0]
+		for _, sink := range combo[1:] {
+			if ec[source][sink] < ec[source][nearest] {
+				nearest = sink
+			}
+		}
+		if vertexWeights[source] > 0 && ec[source][nearest] > o.maxCost {
+			return nil, false
+		}
+		sinks[source] = nearest
+	}
 
+	return sinks, true
+}
+
+// capacitatedAssignment finds the best assignment of vertices to sinks in
+// combo that doesn't exceed any sink's capacity or the machines available,
+// returning its score and the sink serving each vertex. Sinks always serve
+// themselves. Vertices are assigned by depth-first search, heaviest first,
+// pruned by the score of assigning the remaining vertices to their nearest
+// allowed sinks and by whether the sinks have room left for them. The search
+// can take time exponential in the number of vertices, so it gives up and
+// returns false once ctx is done.
+func (g *BruteForcer) capacitatedAssignment(ctx context.Context, ec, wec [][]float64, combo []int, vertexWeights []float64, o *options) (score, []int, bool) {
 	var (
-		bestCombo     = make([]int, k)
-		bestComboCost = math.MaxFloat64
+		total     = sum(vertexWeights)
+		tolerance = 1e-9 * total
+		remaining = make(map[int]float64, len(combo))
+		served    = make(map[int]float64, len(combo))
+		machines  int
+
+		// capacity the sinks have left, which may be +Inf
+		free float64
 	)
 
-	combos := newCombinationEnumerator(len(g.Vertices), k)
-	for combos.next() {
-		if cc := g.comboCost(wec, combos.State); cc < bestComboCost {
-			copy(bestCombo, combos.State)
-			bestComboCost = cc
+	for _, sink := range combo {
+		remaining[sink] = o.capacity(sink, total) - vertexWeights[sink]
+		if remaining[sink] < -tolerance {
+			return score{}, nil, false
 		}
+		served[sink] = vertexWeights[sink]
+		machines += o.sinkMachines(served[sink], total)
+		free += remaining[sink]
 	}
-
-	ret := make([]string, k)
-	for i := range ret {
-		ret[i] = g.Vertices[bestCombo[i]]
+	if machines > o.machines {
+		return score{}, nil, false
 	}
-	slices.Sort(ret)
 
-	return bestComboCost, ret, nil
-}
+	sources := make([]int, 0, len(g.Vertices)-len(combo))
+	for v := range g.Vertices {
+		if _, isSink := remaining[v]; !isSink {
+			sources = append(sources, v)
+		}
+	}
+	sort.SliceStable(sources, func(i, j int) bool {
+		return vertexWeights[sources[i]] > vertexWeights[sources[j]]
+	})
 
-func (g *BruteForcer) weightedEdgeCosts(vertexWeights []float64) [][]float64 {
-	wec := make([][]float64, len(g.Vertices))
-	for j := range wec {
-		wec[j] = make([]float64, len(g.Vertices))
-	}
-	for j, row := range g.EdgeCosts {
-		a := j + 1
-		for b, cost := range row {
-			wec[a][b] = vertexWeights[a] * cost
-			wec[b][a] = vertexWeights[b] * cost
+	// allowed sinks for each source, cheapest first, a lower bound on the
+	// score of assigning sources[i:] and their total weight.
+	sinks := make([][]int, len(sources))
+	lowerBound := make([]score, len(sources)+1)
+	rest := make([]float64, len(sources)+1)
+	for i := len(sources) - 1; i >= 0; i-- {
+		source := sources[i]
+		rest[i] = rest[i+1] + vertexWeights[source]
+		for _, sink := range combo {
+			if vertexWeights[source] <= 0 || ec[source][sink] <= o.maxCost {
+				sinks[i] = append(sinks[i], sink)
+			}
+		}
+		if len(sinks[i]) == 0 {
+			return score{}, nil, false
 		}
+		sort.Slice(sinks[i], func(a, b int) bool {
+			return ec[source][sinks[i][a]] < ec[source][sinks[i][b]]
+		})
+		nearest := sinks[i][0]
+		lowerBound[i] = lowerBound[i+1].add(o, ec[source][nearest], wec[source][nearest], vertexWeights[source])
 	}
-	return wec
-}
 
-func (g *BruteForcer) CombinationCost(combo []string, vertexWeights []float64) (float64, error) {
-	icombo := make([]int, 0, len(combo))
-	for _, c := range combo {
-		i, ok := g.vmap[c]
-		if !ok {
-			return 0, fmt.Errorf("unknown vertex %q", c)
+	var (
+		best         score
+		found        bool
+		assigned     = make([]int, len(g.Vertices))
+		bestAssigned = make([]int, len(g.Vertices))
+		nodes        int
+		stopped      bool
+	)
+	for _, sink := range combo {
+		assigned[sink] = sink
+	}
+
+	var assign func(i int, cs score)
+	assign = func(i int, cs score) {
+		if nodes++; stopped || nodes%ctxCheckInterval == 0 && ctx.Err() != nil {
+			stopped = true
+			return
+		}
+		if rest[i] > free+tolerance {
+			return
+		}
+		if found && !merge(o, cs, lowerBound[i]).less(best) {
+			return
+		}
+		if i == len(sources) {
+			best, found = cs, true
+			copy(bestAssigned, assigned)
+			return
+		}
+
+		source := sources[i]
+		for _, sink := range sinks[i] {
+			if vertexWeights[source] > remaining[sink]+tolerance {
+				continue
+			}
+			more := o.sinkMachines(served[sink]+vertexWeights[source], total) - o.sinkMachines(served[sink], total)
+			if machines+more > o.machines {
+				continue
+			}
+			remaining[sink] -= vertexWeights[source]
+			served[sink] += vertexWeights[source]
+			free -= vertexWeights[source]
+			machines += more
+			assigned[source] = sink
+			assign(i+1, cs.add(o, ec[source][sink], wec[source][sink], vertexWeights[source]))
+			remaining[sink] += vertexWeights[source]
+			served[sink] -= vertexWeights[source]
+			free += vertexWeights[source]
+			machines -= more
 		}
-		icombo = append(icombo, i)
 	}
+	assign(0, score{})
 
-	wec := g.weightedEdgeCosts(vertexWeights)
+	if !found || stopped {
+		return score{}, nil, false
+	}
+
+	return best, bestAssigned, true
+}
 
-	return g.comboCost(wec, icombo), nil
+// plus adds a cost that isn't from serving a vertex, like the cost of sinks.
+func (s score) plus(o *options, cost float64) score {
+	if o.objective == ObjectiveSum {
+		return score{cost: s.cost + cost}
+	}
+	return score{cost: s.cost + cost, total: s.total + cost}
+}
+
+// merge combines the scores of disjoint sets of vertices.
+func merge(o *options, a, b score) score {
+	if o.objective == ObjectiveSum {
+		return score{cost: a.cost + b.cost}
+	}
+	return score{cost: math.Max(a.cost, b.cost), total: a.total + b.total}
 }
 
 func (g *BruteForcer) comboCost(wec [][]float64, combo []int) float64 {
@@ -259,7 +576,7 @@ func (g *BruteForcer) comboCost(wec [][]float64, combo []int) float64 {
 type combinationEnumerator struct {
 	State            []int
 	readable, resume chan struct{}
-	once             sync.Once
+	once, stopOnce   sync.Once
 }
 
 func newCombinationEnumerator(n, k int) *combinationEnumerator {
@@ -279,7 +596,11 @@ func newCombinationEnumerator(n, k int) *combinationEnumerator {
 
 func (ce *combinationEnumerator) enumerateCombinations(n, k, start int) bool {
 	if k == 0 {
-		ce.readable <- struct{}{}
+		select {
+		case ce.readable <- struct{}{}:
+		case <-ce.resume: // closed by stop
+			return false
+		}
 		if _, ok := <-ce.resume; !ok {
 			return false
 		}
@@ -305,3 +626,11 @@ func (ce *combinationEnumerator) next() bool {
 	_, ok := <-ce.readable
 	return ok
 }
+
+// stop ends the enumeration early so its goroutine exits. next mustn't be
+// called after stop.
+func (ce *combinationEnumerator) stop() {
+	ce.stopOnce.Do(func() {
+		close(ce.resume)
+	})
+}
@@ -1,30 +1,18 @@
 package graph
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/alecthomas/assert/v2"
 	"golang.org/x/exp/constraints"
+	"golang.org/x/exp/slices"
 )
 
-func TestEdge(t *testing.T) {
-	g := &Graph{Vertices: []string{"A", "B", "C"}}
-	a, b, c := 0, 1, 2
-
-	// cols - A, B, C, AB, AC, BA, BC, CA, CB
-	//        0  1  2  3   4   5   6   7   8
-
-	assert.Equal(t, 3, g.edge(a, b))
-	assert.Equal(t, 4, g.edge(a, c))
-	assert.Equal(t, 5, g.edge(b, a))
-	assert.Equal(t, 6, g.edge(b, c))
-	assert.Equal(t, 7, g.edge(c, a))
-	assert.Equal(t, 8, g.edge(c, b))
-}
-
 func TestGraphMatchesBruteForce(t *testing.T) {
 	const maxN = 20
 
@@ -36,25 +24,930 @@ func TestGraphMatchesBruteForce(t *testing.T) {
 
 	for n := 2; n <= maxN; n++ {
 		bf := &BruteForcer{Vertices: vertices[:n], EdgeCosts: edgeCosts[:n-1]}
-		g, err := NewGraph(vertices[:n], edgeCosts[:n-1])
-		assert.NoError(t, err)
+		solvers := exactSolvers(t, vertices[:n], edgeCosts[:n-1])
 
 		for k := 1; k < n; k++ {
 			t.Run(fmt.Sprintf("%d-choose-%d", n, k), func(t *testing.T) {
-				bfCost, bfPicks, err := bf.Solve(k, weights[:n])
+				bfCost, bfPicks, err := bf.Solve(context.Background(), k, weights[:n])
 				assert.NoError(t, err)
+				t.Logf("bf     - %10.5f %v", bfCost, bfPicks)
+
+				for sName, s := range solvers {
+					cost, picks, err := s.Solve(context.Background(), k, weights[:n])
+					assert.NoError(t, err, sName)
+					t.Logf("%-6s - %10.5f %v", sName, cost, picks)
+
+					assert.True(t, math.Abs(bfCost-cost) <= 0.0001*bfCost, "%s: expected %f to be near %f", sName, cost, bfCost)
+					assert.Equal(t, bfPicks, picks, sName)
+				}
+			})
+		}
+	}
+}
+
+func TestCapacitiesMatchBruteForce(t *testing.T) {
+	const maxN = 9
+
+	vertices, edgeCosts, weights := testData(maxN)
+
+	for n := 3; n <= maxN; n++ {
+		bf := &BruteForcer{Vertices: vertices[:n], EdgeCosts: edgeCosts[:n-1]}
+		solvers := exactSolvers(t, vertices[:n], edgeCosts[:n-1])
+
+		capacities := make([]float64, n)
+		for i := range capacities {
+			capacities[i] = 0.2 + rand.Float64()*0.6
+		}
+		opt := WithCapacities(capacities)
 
-				gCost, gPicks, err := g.Solve(k, weights[:n])
+		for k := 2; k < n; k++ {
+			t.Run(fmt.Sprintf("%d-choose-%d", n, k), func(t *testing.T) {
+				bfCost, bfPicks, bfErr := bf.Solve(context.Background(), k, weights[:n], opt)
+
+				for sName, s := range solvers {
+					cost, picks, err := s.Solve(context.Background(), k, weights[:n], opt)
+					if bfErr != nil {
+						assert.IsError(t, bfErr, ErrInfeasible)
+						assert.IsError(t, err, ErrInfeasible, sName)
+						continue
+					}
+					assert.NoError(t, err, sName)
+
+					t.Logf("bf     - %10.5f %v", bfCost, bfPicks)
+					t.Logf("%-6s - %10.5f %v", sName, cost, picks)
+
+					assert.True(t, math.Abs(bfCost-cost)/bfCost < 0.0001, "%s: expected %f to be near %f", sName, cost, bfCost)
+					assert.Equal(t, bfPicks, picks, sName)
+				}
+				if bfErr != nil {
+					return
+				}
+
+				uncapped, _, err := bf.Solve(context.Background(), k, weights[:n])
 				assert.NoError(t, err)
+				assert.True(t, uncapped <= bfCost*1.0001)
+			})
+		}
+	}
+}
 
-				t.Logf("bf     - %10.5f %v", bfCost, bfPicks)
-				t.Logf("graph  - %10.5f %v", gCost, gPicks)
+func TestObjectivesMatchBruteForce(t *testing.T) {
+	const maxN = 8
+
+	vertices, edgeCosts, weights := testData(maxN)
+	weights[2] = 0
+
+	cases := map[string][]Option{
+		"max":          {WithObjective(ObjectiveMax)},
+		"max-weighted": {WithObjective(ObjectiveMaxWeighted)},
+		"max-cost":     {WithMaxCost(100)},
+		"max-capacity": {WithObjective(ObjectiveMax), WithCapacities([]float64{.5, .5, .5, .5, .5, .5})},
+	}
+
+	for name, opts := range cases {
+		nMax := maxN
+		if name == "max-capacity" {
+			nMax = 6
+		}
+
+		for n := 3; n <= nMax; n++ {
+			bf := NewBruteForcer(vertices[:n], edgeCosts[:n-1])
+			solvers := exactSolvers(t, vertices[:n], edgeCosts[:n-1])
+
+			for k := 1; k < n; k++ {
+				t.Run(fmt.Sprintf("%s-%d-choose-%d", name, n, k), func(t *testing.T) {
+					bfCost, bfPicks, bfErr := bf.Solve(context.Background(), k, weights[:n], opts...)
+
+					for sName, s := range solvers {
+						cost, picks, err := s.Solve(context.Background(), k, weights[:n], opts...)
+						if bfErr != nil {
+							assert.IsError(t, bfErr, ErrInfeasible)
+							assert.IsError(t, err, ErrInfeasible, sName)
+							continue
+						}
+						assert.NoError(t, err, sName)
+
+						t.Logf("bf     - %10.5f %v", bfCost, bfPicks)
+						t.Logf("%-6s - %10.5f %v", sName, cost, picks)
+
+						assert.True(t, math.Abs(bfCost-cost) <= math.Max(0.0001*bfCost, 1e-6), "%s: expected %f to be near %f", sName, cost, bfCost)
+						assert.Equal(t, bfPicks, picks, sName)
+					}
+					if bfErr != nil {
+						return
+					}
+
+					cost, err := bf.CombinationCost(context.Background(), bfPicks, weights[:n], opts...)
+					assert.NoError(t, err)
+					assert.Equal(t, bfCost, cost)
+				})
+			}
+		}
+	}
+}
+
+func TestObjectives(t *testing.T) {
+	// A is cheapest on average, but C is closest to the furthest vertex.
+	//   A-B 10, A-C 30, A-D 80, B-C 40, B-D 90, C-D 50
+	bf := NewBruteForcer([]string{"A", "B", "C", "D"}, [][]float64{{10}, {30, 40}, {80, 90, 50}})
+	weights := []float64{0.4, 0.4, 0.1, 0.1}
+
+	cost, picks, err := bf.Solve(context.Background(), 1, weights)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A"}, picks)
+	assert.True(t, math.Abs(cost-15) < 1e-9)
+
+	cost, picks, err = bf.Solve(context.Background(), 1, weights, WithObjective(ObjectiveMax))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"C"}, picks)
+	assert.Equal(t, 50.0, cost)
+
+	// weighted: A=max(4, 3, 8), B=max(4, 4, 9), C=max(12, 16, 5)
+	cost, picks, err = bf.Solve(context.Background(), 1, weights, WithObjective(ObjectiveMaxWeighted))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A"}, picks)
+	assert.Equal(t, 8.0, cost)
+
+	cost, picks, err = bf.Solve(context.Background(), 1, weights, WithMaxCost(60))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"C"}, picks)
+
+	// D has no users, so it doesn't need to be close
+	weights[3] = 0
+	_, picks, err = bf.Solve(context.Background(), 1, weights, WithObjective(ObjectiveMax))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A"}, picks)
+
+	_, _, err = bf.Solve(context.Background(), 1, weights, WithMaxCost(20))
+	assert.IsError(t, err, ErrInfeasible)
+}
+
+func TestCapacities(t *testing.T) {
+	// A is cheap to reach from everywhere, but can only serve half the weight
+	bf := NewBruteForcer([]string{"A", "B", "C"}, [][]float64{{10}, {10, 50}})
+	weights := []float64{0.2, 0.4, 0.4}
+
+	cost, picks, err := bf.Solve(context.Background(), 1, weights)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A"}, picks)
+	assert.Equal(t, 8.0, cost)
+
+	cost, picks, err = bf.Solve(context.Background(), 1, weights, WithCapacities([]float64{0.5, 1, 1}))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"B"}, picks)
+	assert.Equal(t, 22.0, cost)
+
+	_, _, err = bf.Solve(context.Background(), 1, weights, WithCapacities([]float64{0.5, 0.5, 0.5}))
+	assert.IsError(t, err, ErrInfeasible)
+
+	cost, err = bf.CombinationCost(context.Background(), []string{"A", "C"}, weights, WithCapacities([]float64{0.6, 1, 1}))
+	assert.NoError(t, err)
+	assert.Equal(t, 4.0, cost)
+
+	cost, err = bf.CombinationCost(context.Background(), []string{"A", "C"}, weights, WithCapacities([]float64{0.5, 1, 1}))
+	assert.NoError(t, err)
+	assert.Equal(t, 20.0, cost)
+
+	_, err = bf.CombinationCost(context.Background(), []string{"A", "C"}, weights, WithCapacities([]float64{0.5, 1, 0.4}))
+	assert.IsError(t, err, ErrInfeasible)
+}
+
+func TestCapacitiesTooSmall(t *testing.T) {
+	const n, k = 19, 3
+
+	vertices, edgeCosts, weights := testData(n)
+	capacities := make([]float64, n)
+	for i := range capacities {
+		capacities[i] = 0.32
+	}
+
+	solvers := exactSolvers(t, vertices, edgeCosts)
+	solvers["bf"] = NewBruteForcer(vertices, edgeCosts)
+	solvers["heuristic"] = NewHeuristicSolver(vertices, edgeCosts)
+
+	// no k sinks can serve everyone, which is known without searching
+	for sName, s := range solvers {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		_, _, err := s.Solve(ctx, k, weights, WithCapacities(capacities))
+		cancel()
+		assert.IsError(t, err, ErrInfeasible, sName)
+	}
+
+	_, _, err := solvers["bf"].Solve(context.Background(), k, weights, WithMachines(k-1, 1))
+	assert.IsError(t, err, ErrInfeasible)
+
+	// searching for an assignment stops once ctx is done
+	for i := range capacities {
+		capacities[i] = 0.4
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	bf := NewBruteForcer(vertices, edgeCosts)
+	_, err = bf.Assign(ctx, vertices[:k], weights, WithCapacities(capacities))
+	assert.IsError(t, err, context.Canceled)
+	_, err = bf.CombinationCost(ctx, vertices[:k], weights, WithCapacities(capacities))
+	assert.IsError(t, err, context.Canceled)
+	_, _, err = bf.Explain(ctx, vertices[:k], weights, WithCapacities(capacities))
+	assert.IsError(t, err, context.Canceled)
+}
+
+func TestRequiredExcludedMatchBruteForce(t *testing.T) {
+	const n = 7
+
+	vertices, edgeCosts, weights := testData(n)
+	bf := NewBruteForcer(vertices, edgeCosts)
+	solvers := exactSolvers(t, vertices, edgeCosts)
+
+	opts := []Option{WithRequired("01", "04"), WithExcluded("00", "05")}
+
+	for k := 1; k < n; k++ {
+		t.Run(fmt.Sprintf("%d-choose-%d", n, k), func(t *testing.T) {
+			bfCost, bfPicks, bfErr := bf.Solve(context.Background(), k, weights, opts...)
+
+			if k < 2 || k > 5 {
+				assert.IsError(t, bfErr, ErrInfeasible)
+				for sName, s := range solvers {
+					_, _, err := s.Solve(context.Background(), k, weights, opts...)
+					assert.IsError(t, err, ErrInfeasible, sName)
+				}
+				return
+			}
+			assert.NoError(t, bfErr)
+			t.Logf("bf     - %10.5f %v", bfCost, bfPicks)
+
+			for sName, s := range solvers {
+				cost, picks, err := s.Solve(context.Background(), k, weights, opts...)
+				assert.NoError(t, err, sName)
+				t.Logf("%-6s - %10.5f %v", sName, cost, picks)
+
+				assert.True(t, math.Abs(bfCost-cost) <= 0.0001*bfCost, "%s: expected %f to be near %f", sName, cost, bfCost)
+				assert.Equal(t, bfPicks, picks, sName)
+			}
+			assert.True(t, slices.Contains(bfPicks, "01"))
+			assert.True(t, slices.Contains(bfPicks, "04"))
+			assert.False(t, slices.Contains(bfPicks, "00"))
+			assert.False(t, slices.Contains(bfPicks, "05"))
+		})
+	}
+}
+
+func TestRequiredExcluded(t *testing.T) {
+	//   A-B 10, A-C 30, B-C 40
+	bf := NewBruteForcer([]string{"A", "B", "C"}, [][]float64{{10}, {30, 40}})
+	weights := []float64{0.5, 0.25, 0.25}
+
+	_, picks, err := bf.Solve(context.Background(), 1, weights)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A"}, picks)
+
+	_, picks, err = bf.Solve(context.Background(), 1, weights, WithExcluded("A"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"B"}, picks)
+
+	_, picks, err = bf.Solve(context.Background(), 1, weights, WithRequired("C"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"C"}, picks)
+
+	_, picks, err = bf.Solve(context.Background(), 2, weights, WithRequired("C"), WithExcluded("A"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"B", "C"}, picks)
+
+	_, _, err = bf.Solve(context.Background(), 1, weights, WithRequired("B", "C"))
+	assert.IsError(t, err, ErrInfeasible)
+
+	_, _, err = bf.Solve(context.Background(), 1, weights, WithExcluded("A", "B", "C"))
+	assert.IsError(t, err, ErrInfeasible)
+
+	_, _, err = bf.Solve(context.Background(), 1, weights, WithRequired("A"), WithExcluded("A"))
+	assert.Error(t, err)
+
+	_, _, err = bf.Solve(context.Background(), 1, weights, WithRequired("D"))
+	assert.Error(t, err)
+}
+
+func TestAssign(t *testing.T) {
+	// A is cheap to reach from everywhere, but can only serve half the weight
+	bf := NewBruteForcer([]string{"A", "B", "C"}, [][]float64{{10}, {10, 50}})
+	weights := []float64{0.2, 0.4, 0.4}
+
+	assignments, err := bf.Assign(context.Background(), []string{"A", "C"}, weights)
+	assert.NoError(t, err)
+	assert.Equal(t, []Assignment{
+		{Vertex: "A", Sink: "A", Cost: 0, Weight: 0.2, Backup: "C", BackupCost: 10},
+		{Vertex: "B", Sink: "A", Cost: 10, Weight: 0.4, Backup: "C", BackupCost: 50},
+		{Vertex: "C", Sink: "C", Cost: 0, Weight: 0.4, Backup: "A", BackupCost: 10},
+	}, assignments)
+
+	assignments, err = bf.Assign(context.Background(), []string{"A", "C"}, weights, WithCapacities([]float64{0.5, 1, 1}))
+	assert.NoError(t, err)
+	assert.Equal(t, []Assignment{
+		{Vertex: "A", Sink: "A", Cost: 0, Weight: 0.2, Backup: "C", BackupCost: 10},
+		{Vertex: "B", Sink: "C", Cost: 50, Weight: 0.4, Backup: "A", BackupCost: 10},
+		{Vertex: "C", Sink: "C", Cost: 0, Weight: 0.4, Backup: "A", BackupCost: 10},
+	}, assignments)
+
+	_, err = bf.Assign(context.Background(), []string{"A"}, weights, WithMaxCost(5))
+	assert.IsError(t, err, ErrInfeasible)
+
+	_, err = bf.Assign(context.Background(), []string{"D"}, weights)
+	assert.Error(t, err)
+}
+
+func TestFailoverMatchesBruteForce(t *testing.T) {
+	const n = 7
+
+	vertices, edgeCosts, weights := testData(n)
+
+	bf := NewBruteForcer(vertices, edgeCosts)
+	solvers := exactSolvers(t, vertices, edgeCosts)
+
+	cases := map[string][]Option{
+		"expected":     {WithFailover(FailoverExpected, 1)},
+		"worst":        {WithFailover(FailoverWorst, 0.5)},
+		"max-expected": {WithObjective(ObjectiveMax), WithFailover(FailoverExpected, 2)},
+		"capacity":     {WithFailover(FailoverWorst, 1), WithCapacities([]float64{.5, .5, .5, .5, .5, .5, .5})},
+	}
+
+	for name, opts := range cases {
+		for k := 1; k < n; k++ {
+			t.Run(fmt.Sprintf("%s-%d-choose-%d", name, n, k), func(t *testing.T) {
+				bfCost, bfPicks, bfErr := bf.Solve(context.Background(), k, weights, opts...)
+				if k == 1 {
+					assert.IsError(t, bfErr, ErrInfeasible)
+				}
+
+				for sName, s := range solvers {
+					cost, picks, err := s.Solve(context.Background(), k, weights, opts...)
+					if bfErr != nil {
+						assert.IsError(t, err, ErrInfeasible, sName)
+						continue
+					}
+					assert.NoError(t, err, sName)
+					assert.Equal(t, bfCost, cost, sName)
+					assert.Equal(t, bfPicks, picks, sName)
+				}
+			})
+		}
+	}
+}
+
+func TestSinkCostsMatchBruteForce(t *testing.T) {
+	const n = 7
+
+	vertices, edgeCosts, weights := testData(n)
+
+	sinkCosts := make([]float64, n)
+	for i := range sinkCosts {
+		sinkCosts[i] = float64(rand.Intn(100))
+	}
+
+	bf := NewBruteForcer(vertices, edgeCosts)
+	solvers := exactSolvers(t, vertices, edgeCosts)
+
+	cases := map[string][]Option{
+		"budget":     {WithSinkCosts(sinkCosts), WithBudget(150)},
+		"weight":     {WithSinkCosts(sinkCosts), WithSinkCostWeight(0.5)},
+		"max-budget": {WithObjective(ObjectiveMax), WithSinkCosts(sinkCosts), WithBudget(150)},
+		"max-weight": {WithObjective(ObjectiveMax), WithSinkCosts(sinkCosts), WithSinkCostWeight(0.5)},
+	}
+
+	for name, opts := range cases {
+		for k := 1; k < n; k++ {
+			t.Run(fmt.Sprintf("%s-%d-choose-%d", name, n, k), func(t *testing.T) {
+				bfCost, bfPicks, bfErr := bf.Solve(context.Background(), k, weights, opts...)
+
+				for sName, s := range solvers {
+					cost, picks, err := s.Solve(context.Background(), k, weights, opts...)
+					if bfErr != nil {
+						assert.IsError(t, bfErr, ErrInfeasible)
+						assert.IsError(t, err, ErrInfeasible, sName)
+						continue
+					}
+					assert.NoError(t, err, sName)
+					assert.True(t, math.Abs(bfCost-cost) <= math.Max(0.0001*bfCost, 1e-6), "%s: expected %f to be near %f", sName, cost, bfCost)
+					assert.Equal(t, bfPicks, picks, sName)
+				}
+			})
+		}
+	}
+}
+
+func TestSinkCosts(t *testing.T) {
+	vertices := []string{"a", "b", "c"}
+	edgeCosts := [][]float64{{10}, {20, 30}}
+	weights := []float64{.5, .25, .25}
+	sinkCosts := []float64{100, 10, 10}
+
+	bf := NewBruteForcer(vertices, edgeCosts)
+
+	_, picks, err := bf.Solve(context.Background(), 1, weights)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, picks)
+
+	// a is over budget
+	_, picks, err = bf.Solve(context.Background(), 1, weights, WithSinkCosts(sinkCosts), WithBudget(50))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b"}, picks)
+
+	_, _, err = bf.Solve(context.Background(), 3, weights, WithSinkCosts(sinkCosts), WithBudget(50))
+	assert.IsError(t, err, ErrInfeasible)
+
+	cost, err := bf.CombinationCost(context.Background(), []string{"b", "c"}, weights, WithSinkCosts(sinkCosts), WithSinkCostWeight(0.1))
+	assert.NoError(t, err)
+	assert.Equal(t, 5+0.1*20, cost)
+}
+
+func TestDirectedMatchesBruteForce(t *testing.T) {
+	const n = 7
+
+	vertices, _, weights := testData(n)
+	edgeCosts := directedTestData(n)
+
+	bf := NewBruteForcer(vertices, edgeCosts)
+	solvers := exactSolvers(t, vertices, edgeCosts)
+
+	cases := map[string][]Option{
+		"sum": nil,
+		"max": {WithObjective(ObjectiveMax)},
+	}
+
+	for name, opts := range cases {
+		for k := 1; k < n; k++ {
+			t.Run(fmt.Sprintf("%s-%d-choose-%d", name, n, k), func(t *testing.T) {
+				bfCost, bfPicks, err := bf.Solve(context.Background(), k, weights, opts...)
+				assert.NoError(t, err)
+
+				for sName, s := range solvers {
+					cost, picks, err := s.Solve(context.Background(), k, weights, opts...)
+					assert.NoError(t, err, sName)
+					assert.True(t, math.Abs(bfCost-cost) <= math.Max(0.0001*bfCost, 1e-6), "%s: expected %f to be near %f", sName, cost, bfCost)
+					assert.Equal(t, bfPicks, picks, sName)
+				}
+			})
+		}
+	}
+}
+
+func TestDirected(t *testing.T) {
+	vertices := []string{"a", "b", "c"}
+	weights := []float64{.5, .25, .25}
+
+	// a is cheap to reach but far from everything else
+	directed := [][]float64{
+		{0, 50, 50},
+		{10, 0, 30},
+		{10, 30, 0},
+	}
+	bf := NewBruteForcer(vertices, directed)
+
+	cost, picks, err := bf.Solve(context.Background(), 1, weights)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, picks)
+	assert.Equal(t, 5, cost)
+
+	assignments, err := bf.Assign(context.Background(), []string{"b"}, weights)
+	assert.NoError(t, err)
+	assert.Equal(t, 50, assignments[0].Cost)
+
+	// symmetric matrices can still be given as their lower triangle
+	lower := [][]float64{{10}, {10, 30}}
+	symmetric := [][]float64{
+		{0, 10, 10},
+		{10, 0, 30},
+		{10, 30, 0},
+	}
+	assert.Equal(t, symmetric, fullMatrix(3, lower))
+	assert.Equal(t, symmetric, fullMatrix(3, symmetric))
+
+	// the diagonal is ignored
+	symmetric[1][1] = 100
+	assert.Equal(t, fullMatrix(3, lower), fullMatrix(3, symmetric))
+}
+
+func TestFailover(t *testing.T) {
+	//    a  b  c
+	// b 10
+	// c 30 20
+	// d 40 50 60
+	vertices := []string{"a", "b", "c", "d"}
+	edgeCosts := [][]float64{{10}, {30, 20}, {40, 50, 60}}
+	weights := []float64{.25, .25, .25, .25}
+
+	bf := NewBruteForcer(vertices, edgeCosts)
+
+	// a and d serve {a b c} and {d}. if a fails, {a b c} is served by d at
+	// 40+50+60. if d fails, it's served by a at 40.
+	cost, err := bf.CombinationCost(context.Background(), []string{"a", "d"}, weights, WithFailover(FailoverWorst, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, (10+30)/4.0+(40+50+60)/4.0, cost)
+
+	cost, err = bf.CombinationCost(context.Background(), []string{"a", "d"}, weights, WithFailover(FailoverExpected, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, (10+30)/4.0+((40+50+60)/4.0+(10+30+40)/4.0)/2, cost)
+
+	// b and d are cheapest, but only d backs up a, b and c if b fails. a and b
+	// back each other up.
+	_, picks, err := bf.Solve(context.Background(), 2, weights)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "d"}, picks)
+
+	_, picks, err = bf.Solve(context.Background(), 2, weights, WithFailover(FailoverWorst, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, picks)
+
+	assignments, err := bf.Assign(context.Background(), []string{"a", "b"}, weights)
+	assert.NoError(t, err)
+	assert.Equal(t, Assignment{Vertex: "d", Sink: "a", Cost: 40, Weight: .25, Backup: "b", BackupCost: 50}, assignments[3])
+
+	_, err = bf.CombinationCost(context.Background(), []string{"a"}, weights, WithFailover(FailoverWorst, 1))
+	assert.IsError(t, err, ErrInfeasible)
+}
+
+func TestExplain(t *testing.T) {
+	vertices := []string{"a", "b", "c", "d"}
+	edgeCosts := [][]float64{{10}, {20, 30}, {40, 50, 60}}
+	weights := []float64{.4, .3, .2, .1}
+
+	bf := NewBruteForcer(vertices, edgeCosts)
+	ctx := context.Background()
+
+	cost, picks, err := bf.Solve(ctx, 2, weights)
+	assert.NoError(t, err)
+
+	contributions, swaps, err := bf.Explain(ctx, picks, weights)
+	assert.NoError(t, err)
+	assert.Equal(t, len(picks), len(contributions))
+	assert.Equal(t, len(vertices)-len(picks), len(swaps))
+
+	for i, c := range contributions {
+		assert.Equal(t, picks[i], c.Sink)
+		rest := slices.Delete(slices.Clone(picks), i, i+1)
+		restCost, err := bf.CombinationCost(ctx, rest, weights)
+		assert.NoError(t, err)
+		assert.Equal(t, restCost-cost, c.Removal)
+	}
+
+	// the solution is optimal, so no swap helps
+	for _, s := range swaps {
+		assert.False(t, slices.Contains(picks, s.Vertex))
+		assert.True(t, slices.Contains(picks, s.Sink))
+		assert.True(t, s.Delta >= 0, "%+v", s)
+	}
+
+	// removing the only sink is infeasible
+	contributions, swaps, err = bf.Explain(ctx, []string{"a"}, weights)
+	assert.NoError(t, err)
+	assert.True(t, math.IsInf(contributions[0].Removal, 1))
+	assert.Equal(t, Swap{"b", "a", (.4*10 + .2*30 + .1*50) - (.3*10 + .2*20 + .1*40)}, swaps[0])
+
+	// with capacities, swaps can be infeasible
+	_, swaps, err = bf.Explain(ctx, []string{"a", "b"}, weights, WithCapacities([]float64{.6, .6, .2, .1}))
+	assert.NoError(t, err)
+	assert.Equal(t, Swap{Vertex: "c", Delta: math.Inf(1)}, swaps[0])
+
+	_, _, err = bf.Explain(ctx, []string{"e"}, weights)
+	assert.Error(t, err)
+}
+
+func TestMonteCarlo(t *testing.T) {
+	const n, k = 7, 3
+
+	vertices, edgeCosts, weights := testData(n)
+	ctx := context.Background()
+	newSolver := func(vertices []string, edgeCosts [][]float64) (Solver, error) {
+		return NewBruteForcer(vertices, edgeCosts), nil
+	}
+
+	cost, picks, err := NewBruteForcer(vertices, edgeCosts).Solve(ctx, k, weights)
+	assert.NoError(t, err)
+
+	// without noise, every trial is the same
+	solutions, err := MonteCarlo{Trials: 5}.Run(ctx, newSolver, vertices, edgeCosts, k, weights)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(solutions))
+	for _, sol := range solutions {
+		assert.Equal(t, Solution{Cost: cost, Picks: picks}, sol)
+	}
+
+	// trials are reproducible, however many workers solve them
+	stdDevs := make([][]float64, len(edgeCosts))
+	for i, row := range edgeCosts {
+		stdDevs[i] = make([]float64, len(row))
+		for j := range row {
+			stdDevs[i][j] = 5
+		}
+	}
+	mc := MonteCarlo{Trials: 20, Workers: 1, WeightNoise: .5, CostNoise: .2, CostStdDevs: stdDevs, Seed: 42}
+	serial, err := mc.Run(ctx, newSolver, vertices, edgeCosts, k, weights)
+	assert.NoError(t, err)
+	mc.Workers = 4
+	concurrent, err := mc.Run(ctx, newSolver, vertices, edgeCosts, k, weights)
+	assert.NoError(t, err)
+	assert.Equal(t, serial, concurrent)
+	assert.True(t, slices.ContainsFunc(serial, func(sol Solution) bool { return sol.Cost != cost }))
+
+	// the next seed's trials aren't this seed's, shifted by one
+	mc.Seed++
+	shifted, err := mc.Run(ctx, newSolver, vertices, edgeCosts, k, weights)
+	assert.NoError(t, err)
+	assert.NotEqual(t, serial[1:], shifted[:len(shifted)-1])
+
+	// infeasible trials have no picks
+	solutions, err = MonteCarlo{Trials: 2}.Run(ctx, newSolver, vertices, edgeCosts, k, weights, WithRequired(vertices[:k+1]...))
+	assert.NoError(t, err)
+	assert.Equal(t, []Solution{{Cost: math.Inf(1)}, {Cost: math.Inf(1)}}, solutions)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = MonteCarlo{Trials: 5}.Run(canceled, newSolver, vertices, edgeCosts, k, weights)
+	assert.IsError(t, err, ErrIncomplete)
+	assert.IsError(t, err, context.Canceled)
+
+	for _, mc := range []MonteCarlo{{}, {Trials: 1, WeightNoise: 2}, {Trials: 1, CostNoise: -1}, {Trials: 1, CostStdDevs: [][]float64{{1}}}} {
+		_, err = mc.Run(ctx, newSolver, vertices, edgeCosts, k, weights)
+		assert.Error(t, err, "%+v", mc)
+	}
+}
+
+func TestMachinesMatchBruteForce(t *testing.T) {
+	const n = 7
+
+	vertices, edgeCosts, weights := testData(n)
+
+	bf := NewBruteForcer(vertices, edgeCosts)
+	solvers := exactSolvers(t, vertices, edgeCosts)
+
+	cases := map[string][]Option{
+		"sum":        {WithMachines(6, 0.25)},
+		"tight":      {WithMachines(4, 0.3)},
+		"max":        {WithObjective(ObjectiveMax), WithMachines(6, 0.25)},
+		"capacities": {WithCapacities([]float64{0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5}), WithMachines(5, 0.3)},
+	}
+
+	for name, opts := range cases {
+		for k := 1; k < n; k++ {
+			t.Run(fmt.Sprintf("%s-%d-choose-%d", name, n, k), func(t *testing.T) {
+				bfCost, bfPicks, bfErr := bf.Solve(context.Background(), k, weights, opts...)
+				if bfErr == nil {
+					o := newOptions(opts)
+					assignments, err := bf.Assign(context.Background(), bfPicks, weights, opts...)
+					assert.NoError(t, err)
+					_, err = AllocateMachines(assignments, o.machines, o.machineCapacity)
+					assert.NoError(t, err)
+				}
+
+				for sName, s := range solvers {
+					cost, picks, err := s.Solve(context.Background(), k, weights, opts...)
+					if bfErr != nil {
+						assert.IsError(t, bfErr, ErrInfeasible)
+						assert.IsError(t, err, ErrInfeasible, sName)
+						continue
+					}
+					assert.NoError(t, err, sName)
+					assert.True(t, math.Abs(bfCost-cost) <= math.Max(0.0001*bfCost, 1e-6), "%s: expected %f to be near %f", sName, cost, bfCost)
+					assert.Equal(t, bfPicks, picks, sName)
+				}
+			})
+		}
+	}
+}
+
+func TestAllocateMachines(t *testing.T) {
+	assignments := []Assignment{
+		{Vertex: "a", Sink: "b", Weight: .1},
+		{Vertex: "b", Sink: "b", Weight: .3},
+		{Vertex: "c", Sink: "c", Weight: .05},
+		{Vertex: "d", Sink: "b", Weight: .25},
+		{Vertex: "e", Sink: "e", Weight: .3},
+	}
+
+	// b needs 3 machines, c and e need 1 and 2. b is the busiest per machine, so
+	// it gets both spares.
+	allocations, err := AllocateMachines(assignments, 8, .25)
+	assert.NoError(t, err)
+	assert.Equal(t, []Allocation{
+		{Sink: "b", Weight: .65, Machines: 5},
+		{Sink: "c", Weight: .05, Machines: 1},
+		{Sink: "e", Weight: .3, Machines: 2},
+	}, allocations)
+
+	allocations, err = AllocateMachines(assignments, 6, .25)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3, 1, 2}, []int{allocations[0].Machines, allocations[1].Machines, allocations[2].Machines})
+
+	_, err = AllocateMachines(assignments, 5, .25)
+	assert.IsError(t, err, ErrInfeasible)
+}
+
+func TestHeuristicSolver(t *testing.T) {
+	const maxN = 10
+
+	vertices, edgeCosts, weights := testData(maxN)
+
+	cases := map[string][]Option{
+		"sum":      nil,
+		"max":      {WithObjective(ObjectiveMax)},
+		"capacity": {WithCapacities([]float64{.4, .4, .4, .4, .4, .4, .4, .4, .4, .4})},
+		"required": {WithRequired("02"), WithExcluded("01")},
+		"failover": {WithFailover(FailoverExpected, 1)},
+		"budget":   {WithSinkCosts([]float64{1, 9, 2, 8, 3, 7, 4, 6, 5, 5}), WithBudget(20), WithSinkCostWeight(1)},
+	}
+
+	for name, opts := range cases {
+		for n := 3; n <= maxN; n++ {
+			bf := NewBruteForcer(vertices[:n], edgeCosts[:n-1])
+			h := NewHeuristicSolver(vertices[:n], edgeCosts[:n-1])
+
+			for k := 1; k < n; k++ {
+				t.Run(fmt.Sprintf("%s-%d-choose-%d", name, n, k), func(t *testing.T) {
+					bfCost, _, bfErr := bf.Solve(context.Background(), k, weights[:n], opts...)
+					hCost, hPicks, hErr := h.Solve(context.Background(), k, weights[:n], opts...)
+
+					if bfErr != nil {
+						assert.IsError(t, hErr, ErrInfeasible)
+						return
+					}
+					assert.NoError(t, hErr)
+					assert.Equal(t, k, len(hPicks))
+
+					lowerBound, err := h.LowerBound(k, weights[:n], opts...)
+					assert.NoError(t, err)
 
-				assert.True(t, (bfCost-gCost)/bfCost < 0.0001, "expected %f to be near %f", gCost, bfCost)
-				assert.Equal(t, bfPicks, gPicks)
+					t.Logf("bf        - %10.5f", bfCost)
+					t.Logf("heuristic - %10.5f %v (>= %.5f)", hCost, hPicks, lowerBound)
+
+					tolerance := 1e-9 * math.Max(1, bfCost)
+					assert.True(t, hCost >= bfCost-tolerance, "expected %f to be no better than %f", hCost, bfCost)
+					assert.True(t, lowerBound <= bfCost+tolerance, "expected bound %f to be no more than %f", lowerBound, bfCost)
+
+					cost, err := bf.CombinationCost(context.Background(), hPicks, weights[:n], opts...)
+					assert.NoError(t, err)
+					assert.Equal(t, hCost, cost)
+				})
+			}
+		}
+	}
+}
+
+func TestSolveCanceled(t *testing.T) {
+	vertices, edgeCosts, weights := testData(10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	solvers := exactSolvers(t, vertices, edgeCosts)
+	solvers["bf"] = NewBruteForcer(vertices, edgeCosts)
+	solvers["heuristic"] = NewHeuristicSolver(vertices, edgeCosts)
+
+	for name, solver := range solvers {
+		_, picks, err := solver.Solve(ctx, 3, weights)
+		assert.IsError(t, err, context.Canceled, name)
+		assert.Zero(t, picks, name)
+	}
+}
+
+func TestSolveNMatchesBruteForce(t *testing.T) {
+	const (
+		n            = 7
+		alternatives = 5
+	)
+
+	vertices, edgeCosts, weights := testData(n)
+
+	bf := NewBruteForcer(vertices, edgeCosts)
+
+	solvers := map[string]AlternativesSolver{}
+	for name, s := range exactSolvers(t, vertices, edgeCosts) {
+		solvers[name] = s.(AlternativesSolver)
+	}
+
+	for k := 1; k < n; k++ {
+		bfSolutions, err := bf.SolveN(context.Background(), k, alternatives, weights)
+		assert.NoError(t, err)
+		assert.Equal(t, alternatives, len(bfSolutions))
+
+		bfCost, bfPicks, err := bf.Solve(context.Background(), k, weights)
+		assert.NoError(t, err)
+		assert.Equal(t, Solution{Cost: bfCost, Picks: bfPicks}, bfSolutions[0])
+
+		for name, s := range solvers {
+			t.Run(fmt.Sprintf("%s-%d-choose-%d", name, n, k), func(t *testing.T) {
+				solutions, err := s.SolveN(context.Background(), k, alternatives, weights)
+				assert.NoError(t, err)
+				assert.Equal(t, len(bfSolutions), len(solutions))
+
+				seen := map[string]bool{}
+				for i, sol := range solutions {
+					assert.True(t, math.Abs(bfSolutions[i].Cost-sol.Cost) <= math.Max(0.0001*sol.Cost, 1e-6), "expected %f to be near %f", sol.Cost, bfSolutions[i].Cost)
+					assert.False(t, seen[fmt.Sprint(sol.Picks)])
+					seen[fmt.Sprint(sol.Picks)] = true
+
+					cost, err := bf.CombinationCost(context.Background(), sol.Picks, weights)
+					assert.NoError(t, err)
+					assert.True(t, math.Abs(cost-sol.Cost) <= math.Max(0.0001*cost, 1e-6), "expected %f to be near %f", sol.Cost, cost)
+				}
 			})
 		}
 	}
+
+	// fewer combinations than asked for
+	for name, s := range solvers {
+		solutions, err := s.SolveN(context.Background(), n-1, 10, weights)
+		assert.NoError(t, err, name)
+		assert.Equal(t, n, len(solutions), name)
+	}
+}
+
+func TestSweepMatchesBruteForce(t *testing.T) {
+	const n = 7
+
+	vertices, edgeCosts, weights := testData(n)
+
+	bf := NewBruteForcer(vertices, edgeCosts)
+	solvers := exactSolvers(t, vertices, edgeCosts)
+
+	cases := map[string][]Option{
+		"sum": nil,
+		"max": {WithObjective(ObjectiveMax)},
+		// no single vertex can serve everyone
+		"capacity": {WithCapacities([]float64{.6, .6, .6, .6, .6, .6, .6})},
+	}
+
+	for name, opts := range cases {
+		t.Run(name, func(t *testing.T) {
+			bfSolutions, err := Sweep(context.Background(), bf, 1, n-1, weights, opts...)
+			assert.NoError(t, err)
+			assert.Equal(t, n-1, len(bfSolutions))
+			if name == "capacity" {
+				assert.True(t, math.IsInf(bfSolutions[0].Cost, 1))
+			}
+
+			for i, s := range bfSolutions {
+				cost, picks, err := bf.Solve(context.Background(), i+1, weights, opts...)
+				if err != nil {
+					assert.IsError(t, err, ErrInfeasible)
+					assert.True(t, math.IsInf(s.Cost, 1))
+					assert.Zero(t, s.Picks)
+					continue
+				}
+				assert.Equal(t, Solution{Cost: cost, Picks: picks}, s)
+			}
+
+			for sName, s := range solvers {
+				solutions, err := Sweep(context.Background(), s, 1, n-1, weights, opts...)
+				assert.NoError(t, err, sName)
+				assert.Equal(t, len(bfSolutions), len(solutions), sName)
+
+				for i, sol := range solutions {
+					if math.IsInf(bfSolutions[i].Cost, 1) {
+						assert.True(t, math.IsInf(sol.Cost, 1), sName)
+						continue
+					}
+					assert.True(t, math.Abs(bfSolutions[i].Cost-sol.Cost) <= math.Max(0.0001*sol.Cost, 1e-6), "%s: expected %f to be near %f", sName, sol.Cost, bfSolutions[i].Cost)
+				}
+			}
+		})
+	}
+}
+
+func TestTopN(t *testing.T) {
+	top := newTopN(2)
+	assert.True(t, top.empty())
+
+	top.offer([]int{0}, score{cost: 3})
+	top.offer([]int{1}, score{cost: 1})
+	assert.True(t, top.full())
+	assert.Equal(t, 3.0, top.worst().cost)
+
+	// ties keep the combination offered first
+	top.offer([]int{2}, score{cost: 1})
+	top.offer([]int{3}, score{cost: 2})
+	top.offer([]int{4}, score{cost: 2})
+
+	assert.Equal(t, []Solution{
+		{Cost: 1, Picks: []string{"b"}},
+		{Cost: 1, Picks: []string{"c"}},
+	}, top.solutions([]string{"a", "b", "c", "d", "e"}))
+}
+
+func TestCombinationEnumeratorStop(t *testing.T) {
+	ce := newCombinationEnumerator(10, 3)
+	ce.stop()
+	for range ce.readable {
+	}
+
+	ce = newCombinationEnumerator(10, 3)
+	assert.True(t, ce.next())
+	assert.True(t, ce.next())
+	ce.stop()
+	for range ce.readable {
+	}
+
+	ce = newCombinationEnumerator(3, 2)
+	for ce.next() {
+	}
+	ce.stop()
 }
 
 func BenchmarkIncreasingNK1(b *testing.B) {
@@ -97,7 +990,7 @@ func benchGraph(b *testing.B, n, k int, vertices []string, edgeCosts [][]float64
 		for i := 0; i < b.N; i++ {
 			g, err := NewGraph(vertices, edgeCosts)
 			assert.NoError(b, err)
-			_, _, err = g.Solve(k, weights)
+			_, _, err = g.Solve(context.Background(), k, weights)
 			assert.NoError(b, err)
 		}
 	})
@@ -106,7 +999,7 @@ func benchGraph(b *testing.B, n, k int, vertices []string, edgeCosts [][]float64
 func benchGraphK(b *testing.B, g *Graph, k int, vertices []string, edgeCosts [][]float64, weights []float64) {
 	b.Run(fmt.Sprintf("graph-choose-%d", k), func(b *testing.B) {
 		for i := 0; i < b.N; i++ {
-			_, _, err := g.Solve(k, weights)
+			_, _, err := g.Solve(context.Background(), k, weights)
 			assert.NoError(b, err)
 		}
 	})
@@ -116,12 +1009,25 @@ func benchBruteForce(b *testing.B, n, k int, vertices []string, edgeCosts [][]fl
 	b.Run(fmt.Sprintf("bf-%d-choose-%d", n, k), func(b *testing.B) {
 		for i := 0; i < b.N; i++ {
 			bf := &BruteForcer{Vertices: vertices, EdgeCosts: edgeCosts}
-			_, _, err := bf.Solve(k, weights)
+			_, _, err := bf.Solve(context.Background(), k, weights)
 			assert.NoError(b, err)
 		}
 	})
 }
 
+// exactSolvers returns the exact solvers compared with brute force. Builds
+// without lpsolve leave out Graph, which is BranchAndBound in them, rather
+// than test it twice under a name that suggests the MILP was covered.
+func exactSolvers(t testing.TB, vertices []string, edgeCosts [][]float64) map[string]Solver {
+	solvers := map[string]Solver{"bnb": NewBranchAndBound(vertices, edgeCosts)}
+	if usesLPSolve {
+		g, err := NewGraph(vertices, edgeCosts)
+		assert.NoError(t, err)
+		solvers["graph"] = g
+	}
+	return solvers
+}
+
 func testData(n int) ([]string, [][]float64, []float64) {
 	vertices := make([]string, n)
 	for i := range vertices {
@@ -144,6 +1050,21 @@ func testData(n int) ([]string, [][]float64, []float64) {
 	return vertices, edgeCosts, weights
 }
 
+// directedTestData returns a full matrix of random edge costs, which differ
+// in each direction.
+func directedTestData(n int) [][]float64 {
+	edgeCosts := make([][]float64, n)
+	for i := range edgeCosts {
+		edgeCosts[i] = make([]float64, n)
+		for j := range edgeCosts[i] {
+			if i != j {
+				edgeCosts[i][j] = rand.Float64() * 200
+			}
+		}
+	}
+	return edgeCosts
+}
+
 func printMatrix[T constraints.Float](label string, m [][]T, precision int) {
 	fmt.Println(label)
 
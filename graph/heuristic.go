@@ -0,0 +1,265 @@
+package graph
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"golang.org/x/exp/slices"
+)
+
+// HeuristicSolver is a Solver that quickly finds good, but not necessarily
+// optimal, sinks. It chooses sinks greedily, both by adding the best vertex
+// until there are k and by dropping the least useful vertex until there are k,
+// then improves the better of the two with Teitz-Bart vertex swaps until no
+// swap helps. With sink costs, the cheapest sinks are a third starting point.
+type HeuristicSolver struct {
+	Vertices  []string
+	EdgeCosts [][]float64
+	bf        *BruteForcer
+}
+
+var _ Solver = (*HeuristicSolver)(nil)
+
+func NewHeuristicSolver(vertices []string, edgeCosts [][]float64) *HeuristicSolver {
+	return &HeuristicSolver{
+		Vertices:  vertices,
+		EdgeCosts: edgeCosts,
+		bf:        NewBruteForcer(vertices, edgeCosts),
+	}
+}
+
+// Solve returns the best sinks found before ctx is done or no swap improves
+// them.
+func (h *HeuristicSolver) Solve(ctx context.Context, k int, vertexWeights []float64, opts ...Option) (float64, []string, error) {
+	o := newOptions(opts)
+
+	required, excluded, err := o.vertexSets(h.Vertices)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	hs := &heuristic{
+		ctx:      ctx,
+		bf:       h.bf,
+		o:        o,
+		k:        k,
+		weights:  vertexWeights,
+		ec:       fullMatrix(len(h.Vertices), h.EdgeCosts),
+		wec:      h.bf.weightedEdgeCosts(vertexWeights),
+		required: required,
+		excluded: excluded,
+	}
+
+	var nRequired, nAvailable int
+	for v := range h.Vertices {
+		if required[v] {
+			nRequired++
+		}
+		if !excluded[v] {
+			nAvailable++
+		}
+	}
+	if k < nRequired || k > nAvailable || !o.enoughCapacity(k, vertexWeights) {
+		return 0, nil, ErrInfeasible
+	}
+
+	best, ok := hs.greedyAdd(ctx)
+	if !ok {
+		return 0, nil, ctx.Err()
+	}
+	if drop, ok := hs.greedyDrop(ctx); ok && drop.better(best) {
+		best = drop
+	}
+	if cheapest := hs.cheapest(); cheapest.better(best) {
+		best = cheapest
+	}
+	best, ok = hs.swap(ctx, best)
+
+	switch {
+	case !best.ok && !ok:
+		return 0, nil, ctx.Err()
+	case !best.ok:
+		return 0, nil, ErrInfeasible
+	case !ok:
+		return best.score.cost, sortedNames(h.Vertices, best.combo), incomplete(ctx)
+	}
+
+	return best.score.cost, sortedNames(h.Vertices, best.combo), nil
+}
+
+// LowerBound returns a lower bound on the optimal cost, to judge how far from
+// optimal the sinks returned by Solve might be.
+func (h *HeuristicSolver) LowerBound(k int, vertexWeights []float64, opts ...Option) (float64, error) {
+	o := newOptions(opts)
+
+	required, excluded, err := o.vertexSets(h.Vertices)
+	if err != nil {
+		return 0, err
+	}
+
+	lowerBound := newBnbSearch(h.bf, o, k, vertexWeights, required, excluded).rootBound()
+	if math.IsInf(lowerBound, 1) {
+		return 0, ErrInfeasible
+	}
+
+	return lowerBound, nil
+}
+
+type heuristic struct {
+	// capacitated combinations are scored by a search that gives up once ctx
+	// is done, and are then infeasible.
+	ctx context.Context
+
+	bf      *BruteForcer
+	o       *options
+	k       int
+	weights []float64
+	ec, wec [][]float64
+
+	required, excluded []bool
+}
+
+// candidate is a combination of sinks and its score. Infeasible candidates are
+// worse than any feasible one.
+type candidate struct {
+	combo []int
+	score score
+	ok    bool
+}
+
+func (c candidate) better(o candidate) bool {
+	return c.ok && (!o.ok || c.score.less(o.score))
+}
+
+func (hs *heuristic) eval(combo []int) candidate {
+	c := candidate{combo: combo}
+	if len(combo) != 0 {
+		c.score, c.ok = hs.bf.comboScore(hs.ctx, hs.ec, hs.wec, combo, hs.weights, hs.o)
+	}
+	return c
+}
+
+// greedyAdd starts with the required sinks and adds the vertex that most
+// improves the score until there are k. It returns false if ctx is done first.
+func (hs *heuristic) greedyAdd(ctx context.Context) (candidate, bool) {
+	var combo []int
+	for v, req := range hs.required {
+		if req {
+			combo = append(combo, v)
+		}
+	}
+	cur := hs.eval(combo)
+
+	for len(cur.combo) < hs.k {
+		if ctx.Err() != nil {
+			return candidate{}, false
+		}
+
+		var best candidate
+		for v := range hs.weights {
+			if hs.excluded[v] || slices.Contains(cur.combo, v) {
+				continue
+			}
+			c := hs.eval(append(slices.Clone(cur.combo), v))
+			if best.combo == nil || c.better(best) {
+				best = c
+			}
+		}
+		cur = best
+	}
+
+	return cur, true
+}
+
+// greedyDrop starts with every vertex that isn't excluded and drops the one
+// whose removal least worsens the score until there are k. It returns false if
+// ctx is done first.
+func (hs *heuristic) greedyDrop(ctx context.Context) (candidate, bool) {
+	var combo []int
+	for v, excl := range hs.excluded {
+		if !excl {
+			combo = append(combo, v)
+		}
+	}
+	cur := hs.eval(combo)
+
+	for len(cur.combo) > hs.k {
+		if ctx.Err() != nil {
+			return candidate{}, false
+		}
+
+		var best candidate
+		for i, v := range cur.combo {
+			if hs.required[v] {
+				continue
+			}
+			c := hs.eval(slices.Delete(slices.Clone(cur.combo), i, i+1))
+			if best.combo == nil || c.better(best) {
+				best = c
+			}
+		}
+		cur = best
+	}
+
+	return cur, true
+}
+
+// cheapest chooses the required sinks and the cheapest others, so there's a
+// combination within budget to start from when the greedy ones are over it.
+func (hs *heuristic) cheapest() candidate {
+	if hs.o.sinkCosts == nil {
+		return candidate{}
+	}
+
+	var combo, optional []int
+	for v := range hs.weights {
+		switch {
+		case hs.required[v]:
+			combo = append(combo, v)
+		case !hs.excluded[v]:
+			optional = append(optional, v)
+		}
+	}
+	sort.SliceStable(optional, func(i, j int) bool {
+		return hs.o.vertexSinkCost(optional[i]) < hs.o.vertexSinkCost(optional[j])
+	})
+
+	return hs.eval(append(combo, optional[:hs.k-len(combo)]...))
+}
+
+// swap tries each vertex that isn't a sink in place of each sink that isn't
+// required, making the best improving swap for each vertex, until a pass over
+// every vertex makes no improvement. It returns false if ctx is done first.
+func (hs *heuristic) swap(ctx context.Context, cur candidate) (candidate, bool) {
+	for improved := true; improved; {
+		improved = false
+
+		for v := range hs.weights {
+			if hs.excluded[v] || slices.Contains(cur.combo, v) {
+				continue
+			}
+			if ctx.Err() != nil {
+				return cur, false
+			}
+
+			best := cur
+			for i, u := range cur.combo {
+				if hs.required[u] {
+					continue
+				}
+				try := slices.Clone(cur.combo)
+				try[i] = v
+				if c := hs.eval(try); c.better(best) {
+					best = c
+				}
+			}
+			if best.better(cur) {
+				cur = best
+				improved = true
+			}
+		}
+	}
+
+	return cur, true
+}
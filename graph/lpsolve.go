@@ -0,0 +1,551 @@
+//go:build cgo && !purego
+
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/btoews/golp"
+)
+
+// Graph solves for the optimal sinks as a mixed integer linear program using
+// lpsolve, which needs cgo. Builds with the purego tag or without cgo use
+// BranchAndBound instead.
+//
+// Some options aren't modeled by the MILP, so Graph leaves solves with them to
+// BranchAndBound. See modeled.
+type Graph struct {
+	Vertices  []string
+	EdgeCosts [][]float64
+	lp        *golp.LP
+	bnb       *BranchAndBound
+}
+
+var (
+	_ AlternativesSolver = (*Graph)(nil)
+	_ SweepSolver        = (*Graph)(nil)
+)
+
+// Create a new graph with named vertices and edge costs. Edge costs are a
+// full matrix, where edgeCosts[source][sink] is the cost of users at source
+// being served by sink. If costs are symmetrical, only half the matrix needs
+// to be specified. For example, the cells marked x should be specified for a
+// graph with 3 vertices.
+//
+//	  |A|B|C|
+//	A | | | |
+//	B |x| | |
+//	C |x|x| |
+func NewGraph(vertices []string, edgeCosts [][]float64) (*Graph, error) {
+	g := &Graph{
+		Vertices:  vertices,
+		EdgeCosts: edgeCosts,
+		bnb:       NewBranchAndBound(vertices, edgeCosts),
+	}
+	if err := g.initLP(); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// modeled returns true if the MILP models every option in o. lpsolve would
+// need a copy of the assignment for each sink failing to model WithFailover.
+// Minimax objectives break ties by minimizing the total cost with the worst
+// cost fixed, which doesn't work once sink costs are traded off against it.
+func modeled(o *options) bool {
+	return o.failover == FailoverNone && (o.objective == ObjectiveSum || o.sinkCostWeight == 0)
+}
+
+// Solve solves the MILP with lpsolve. lpsolve doesn't report sinks until it's
+// finished and can't be interrupted, so ctx is only checked before each solve
+// starts, and a solve that has started runs to completion. Use BranchAndBound
+// where solves need to stop once ctx is done.
+func (g *Graph) Solve(ctx context.Context, k int, vertexWeights []float64, opts ...Option) (float64, []string, error) {
+	o := newOptions(opts)
+	if !modeled(o) {
+		return g.bnb.Solve(ctx, k, vertexWeights, opts...)
+	}
+
+	cost, sinks, err := g.solveCuts(ctx, k, vertexWeights, o, nil)
+	if sinks == nil {
+		return 0, nil, err
+	}
+	return cost, sortedNames(g.Vertices, sinks), err
+}
+
+// SolveN solves repeatedly, adding a no-good cut after each solve to exclude
+// the combinations already found.
+func (g *Graph) SolveN(ctx context.Context, k, n int, vertexWeights []float64, opts ...Option) ([]Solution, error) {
+	o := newOptions(opts)
+	if !modeled(o) {
+		return g.bnb.SolveN(ctx, k, n, vertexWeights, opts...)
+	}
+
+	var (
+		ret  []Solution
+		cuts [][]int
+	)
+	for len(ret) < n {
+		cost, sinks, err := g.solveCuts(ctx, k, vertexWeights, o, cuts)
+		if sinks != nil {
+			ret = append(ret, Solution{Cost: cost, Picks: sortedNames(g.Vertices, sinks)})
+		}
+		switch {
+		case errors.Is(err, ErrInfeasible) && len(ret) > 0:
+			return ret, nil
+		case ctx.Err() != nil && len(ret) > 0:
+			return ret, incomplete(ctx)
+		case err != nil:
+			return nil, err
+		}
+		cuts = append(cuts, sinks)
+	}
+
+	return ret, nil
+}
+
+// solveCuts solves for the best combination of k sinks that isn't in cuts.
+func (g *Graph) solveCuts(ctx context.Context, k int, vertexWeights []float64, o *options, cuts [][]int) (float64, []int, error) {
+	if !o.enoughCapacity(k, vertexWeights) {
+		return 0, nil, ErrInfeasible
+	}
+
+	p, err := g.newProblem(vertexWeights, o, cuts)
+	if err != nil {
+		return 0, nil, err
+	}
+	return p.solve(ctx, k)
+}
+
+// Sweep solves for each k from kMin to kMax, building the LP once and only
+// adding the constraint on k for each solve.
+func (g *Graph) Sweep(ctx context.Context, kMin, kMax int, vertexWeights []float64, opts ...Option) ([]Solution, error) {
+	o := newOptions(opts)
+	if !modeled(o) {
+		return Sweep(ctx, g.bnb, kMin, kMax, vertexWeights, opts...)
+	}
+
+	p, err := g.newProblem(vertexWeights, o, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]Solution, 0, kMax-kMin+1)
+	for k := kMin; k <= kMax; k++ {
+		cost, sinks, err := p.solve(ctx, k)
+		switch {
+		case errors.Is(err, ErrInfeasible):
+			ret = append(ret, Solution{Cost: math.Inf(1)})
+			continue
+		case ctx.Err() != nil:
+			return ret, incomplete(ctx)
+		case err != nil:
+			return nil, err
+		}
+		ret = append(ret, Solution{Cost: cost, Picks: sortedNames(g.Vertices, sinks)})
+	}
+
+	return ret, nil
+}
+
+// problem is the MILP with every constraint that doesn't depend on k. sum
+// minimizes the total weighted cost. For the max objectives, minimax minimizes
+// the worst cost and sum is used to break ties between solutions with the same
+// worst cost.
+type problem struct {
+	g            *Graph
+	weights      []float64
+	o            *options
+	sum, minimax *golp.LP
+}
+
+func (g *Graph) newProblem(vertexWeights []float64, o *options, cuts [][]int) (*problem, error) {
+	p := &problem{g: g, weights: vertexWeights, o: o}
+
+	var err error
+	if p.sum, err = g.constrain(vertexWeights, o, cuts, false); err != nil {
+		return nil, err
+	}
+	if o.objective != ObjectiveSum {
+		if p.minimax, err = g.constrain(vertexWeights, o, cuts, true); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// solve solves for k sinks, returned in index order.
+func (p *problem) solve(ctx context.Context, k int) (float64, []int, error) {
+	if p.minimax == nil {
+		return p.g.solve(ctx, p.sum, k, p.weights, p.o, math.Inf(1))
+	}
+
+	// minimize the worst cost, then minimize the total cost without making the
+	// worst cost any worse. this breaks ties between solutions with the same
+	// worst cost the same way BruteForcer does.
+	worst, worstSinks, err := p.g.solve(ctx, p.minimax, k, p.weights, p.o, math.Inf(1))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	_, sinks, err := p.g.solve(ctx, p.sum, k, p.weights, p.o, worst)
+	if ctx.Err() != nil {
+		return worst, worstSinks, incomplete(ctx)
+	} else if err != nil {
+		return 0, nil, err
+	}
+
+	return worst, sinks, nil
+}
+
+// constrain builds the MILP without the constraint on k. If minimax is set, an
+// extra column Z bounds the cost of every vertex's assignment and the
+// objective is to minimize Z. Otherwise, the objective is the total weighted
+// cost. With WithMachines, integer columns after that count each vertex's
+// machines. Combinations in cuts are excluded.
+func (g *Graph) constrain(vertexWeights []float64, o *options, cuts [][]int, minimax bool) (*golp.LP, error) {
+	nVertices := len(g.Vertices)
+	nEdges := nVertices * (nVertices - 1) / 2
+	nCols := nVertices + nEdges*2
+
+	extraCols, machineCol := 0, nCols
+	if minimax {
+		extraCols++
+		machineCol++
+	}
+	if o.machines > 0 {
+		extraCols += nVertices
+	}
+
+	var lp *golp.LP
+	if extraCols > 0 {
+		var err error
+		if lp, err = g.buildLP(extraCols); err != nil {
+			return nil, err
+		}
+	} else {
+		lp = g.lp.Copy()
+	}
+
+	// constraint: required and excluded sinks are fixed
+	//   A=1
+	//   B=0
+	required, excluded, err := o.vertexSets(g.Vertices)
+	if err != nil {
+		return nil, err
+	}
+	for sink := 0; sink < nVertices; sink++ {
+		switch {
+		case required[sink]:
+			err = lp.AddConstraintSparse([]golp.Entry{g.entry(sink)}, golp.EQ, 1)
+		case excluded[sink]:
+			err = lp.AddConstraintSparse([]golp.Entry{g.entry(sink)}, golp.EQ, 0)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// constraint: combinations already found are excluded (no-good cuts)
+	//   A+B <= 1
+	for _, cut := range cuts {
+		row := make([]golp.Entry, 0, len(cut))
+		for _, sink := range cut {
+			row = append(row, g.entry(sink))
+		}
+		if err := lp.AddConstraintSparse(row, golp.LE, float64(len(cut)-1)); err != nil {
+			return nil, err
+		}
+	}
+
+	// constraint: sinks serve no more than their capacity
+	//   wA*A + wB*BA + wC*CA <= capA
+	if o.capacitated() {
+		total := sum(vertexWeights)
+		for sink := 0; sink < nVertices; sink++ {
+			capacity := o.capacity(sink, total)
+			if math.IsInf(capacity, 1) {
+				continue
+			}
+
+			if err := lp.AddConstraintSparse(g.servedRow(vertexWeights, sink), golp.LE, capacity); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// constraint: sinks have enough machines for what they serve, and at least
+	// one, out of the machines available. other vertices have none.
+	//   wA*A + wB*BA + wC*CA - capM*mA <= 0
+	//   mA - A >= 0
+	//   mA - M*A <= 0
+	//   mA + mB + mC <= M
+	if o.machines > 0 {
+		capacity := o.machineCapacity * sum(vertexWeights)
+		all := make([]golp.Entry, 0, nVertices)
+		for sink := 0; sink < nVertices; sink++ {
+			machines := golp.Entry{Col: machineCol + sink, Val: 1}
+			lp.SetInt(machines.Col, true)
+			all = append(all, machines)
+
+			// tolerate rounding in weights that exactly fill their machines,
+			// like machinesNeeded
+			row := append(g.servedRow(vertexWeights, sink), golp.Entry{Col: machines.Col, Val: -capacity})
+			if err := lp.AddConstraintSparse(row, golp.LE, 1e-9*capacity); err != nil {
+				return nil, err
+			}
+			if err := lp.AddConstraintSparse([]golp.Entry{machines, g.entryVal(-1, sink)}, golp.GE, 0); err != nil {
+				return nil, err
+			}
+			if err := lp.AddConstraintSparse([]golp.Entry{machines, g.entryVal(-float64(o.machines), sink)}, golp.LE, 0); err != nil {
+				return nil, err
+			}
+		}
+		if err := lp.AddConstraintSparse(all, golp.LE, float64(o.machines)); err != nil {
+			return nil, err
+		}
+	}
+
+	// constraint: sinks cost no more than the budget
+	//   fA*A + fB*B + fC*C <= budget
+	if o.sinkCosts != nil && !math.IsInf(o.budget, 1) {
+		row := make([]golp.Entry, 0, nVertices)
+		for sink := 0; sink < nVertices; sink++ {
+			row = append(row, g.entryVal(o.vertexSinkCost(sink), sink))
+		}
+		bound := o.budget + 1e-9*math.Max(1, math.Abs(o.budget))
+		if err := lp.AddConstraintSparse(row, golp.LE, bound); err != nil {
+			return nil, err
+		}
+	}
+
+	costs := fullMatrix(nVertices, g.EdgeCosts)
+
+	// constraint: vertices with weight can't be served by sinks further than
+	// the max cost
+	//   cAB*AB + cAC*AC <= max
+	if !math.IsInf(o.maxCost, 1) {
+		for source := 0; source < nVertices; source++ {
+			if vertexWeights[source] <= 0 {
+				continue
+			}
+			row := make([]golp.Entry, 0, nVertices-1)
+			for sink := 0; sink < nVertices; sink++ {
+				if sink != source {
+					row = append(row, g.entryVal(costs[source][sink], source, sink))
+				}
+			}
+			if err := lp.AddConstraintSparse(row, golp.LE, o.maxCost); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// constraint: each vertex's assignment costs no more than Z
+	//   mAB*AB + mAC*AC - Z <= 0
+	if minimax {
+		if err := g.boundAssignments(lp, vertexWeights, o, golp.Entry{Col: nCols, Val: -1}, 0); err != nil {
+			return nil, err
+		}
+
+		objRow := make([]float64, lp.NumCols())
+		objRow[nCols] = 1
+		lp.SetObjFn(objRow)
+	} else {
+		objRow := make([]float64, lp.NumCols())
+		for source, row := range costs {
+			for sink, cost := range row {
+				if sink != source {
+					objRow[g.edge(source, sink)] = cost * vertexWeights[source]
+				}
+			}
+		}
+		if o.objective == ObjectiveSum {
+			for sink := 0; sink < nVertices; sink++ {
+				objRow[sink] = o.sinkCostWeight * o.vertexSinkCost(sink)
+			}
+		}
+		lp.SetObjFn(objRow)
+	}
+
+	return lp, nil
+}
+
+// servedRow returns the terms for the weight sink serves, including its own.
+//
+//	wA*A + wB*BA + wC*CA
+func (g *Graph) servedRow(vertexWeights []float64, sink int) []golp.Entry {
+	nVertices := len(g.Vertices)
+	row := append(make([]golp.Entry, 0, nVertices+1), g.entryVal(vertexWeights[sink], sink))
+	for source := 0; source < nVertices; source++ {
+		if source != sink {
+			row = append(row, g.entryVal(vertexWeights[source], source, sink))
+		}
+	}
+	return row
+}
+
+// boundAssignments adds a constraint for each vertex that the cost of its
+// assignment, plus extra, is no more than bound.
+func (g *Graph) boundAssignments(lp *golp.LP, vertexWeights []float64, o *options, extra golp.Entry, bound float64) error {
+	nVertices := len(g.Vertices)
+	costs := fullMatrix(nVertices, g.EdgeCosts)
+
+	for source := 0; source < nVertices; source++ {
+		row := make([]golp.Entry, 0, nVertices)
+		for sink := 0; sink < nVertices; sink++ {
+			if sink != source {
+				row = append(row, g.entryVal(o.assignmentCost(costs[source][sink], vertexWeights[source]), source, sink))
+			}
+		}
+		if extra.Val != 0 {
+			row = append(row, extra)
+		}
+		if err := lp.AddConstraintSparse(row, golp.LE, bound); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// solve solves a copy of lp for k sinks, with every vertex's assignment cost
+// bounded by worst. The chosen sinks are returned in index order.
+func (g *Graph) solve(ctx context.Context, lp *golp.LP, k int, vertexWeights []float64, o *options, worst float64) (float64, []int, error) {
+	nVertices := len(g.Vertices)
+	lp = lp.Copy()
+
+	// constraint: must choose k sinks
+	//   A+B+C=k
+	row := make([]golp.Entry, 0, nVertices)
+	for sink := 0; sink < nVertices; sink++ {
+		row = append(row, g.entry(sink))
+	}
+	if err := lp.AddConstraintSparse(row, golp.EQ, float64(k)); err != nil {
+		return 0, nil, err
+	}
+
+	// constraint: each vertex's assignment costs no more than the worst cost
+	//   mAB*AB + mAC*AC <= worst
+	if !math.IsInf(worst, 1) {
+		bound := worst + 1e-9*math.Max(1, worst)
+		if err := g.boundAssignments(lp, vertexWeights, o, golp.Entry{}, bound); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	switch st := lp.Solve(); st {
+	case golp.OPTIMAL:
+	case golp.INFEASIBLE:
+		return 0, nil, ErrInfeasible
+	default:
+		return 0, nil, fmt.Errorf("%s solution", st)
+	}
+
+	vars := lp.Variables()
+	ret := make([]int, 0, k)
+	for i := range g.Vertices {
+		if vars[i] != 0 {
+			ret = append(ret, i)
+		}
+	}
+
+	return lp.Objective(), ret, nil
+}
+
+func (g *Graph) initLP() error {
+	lp, err := g.buildLP(0)
+	if err != nil {
+		return err
+	}
+
+	g.lp = lp
+
+	return nil
+}
+
+// buildLP builds the base LP with columns for vertices and edges, followed by
+// extraCols unconstrained, non-negative columns.
+func (g *Graph) buildLP(extraCols int) (*golp.LP, error) {
+	nVertices := len(g.Vertices)
+	nEdges := nVertices * (nVertices - 1) / 2
+	nCols := nVertices + nEdges*2
+
+	lp := golp.NewLP(0, nCols+extraCols)
+
+	for c := 0; c < nCols; c++ {
+		lp.SetBinary(c, true)
+	}
+
+	for source := 0; source < nVertices; source++ {
+		lp.SetColName(source, g.Vertices[source])
+		sourceOrSink := append(make([]golp.Entry, 0, nVertices), g.entry(source))
+
+		for sink := 0; sink < nVertices; sink++ {
+			if source == sink {
+				continue
+			}
+
+			lp.SetColName(g.edge(source, sink), g.Vertices[source]+"_"+g.Vertices[sink])
+			sourceOrSink = append(sourceOrSink, g.entry(source, sink))
+
+			// O(n^2) constraints: only sinks have incoming edges
+			//   A - BA >= 0
+			//   A - CA >= 0
+			lp.AddConstraintSparse([]golp.Entry{
+				g.entry(sink),
+				g.entryVal(-1, source, sink),
+			}, golp.GE, 0)
+		}
+
+		// O(n) constraints: each vertex must have 1 sink or be a source
+		//   A+AB+AC = 1
+		if err := lp.AddConstraintSparse(sourceOrSink, golp.EQ, 1); err != nil {
+			return nil, err
+		}
+	}
+
+	return lp, nil
+}
+
+func (g *Graph) entry(source int, sink ...int) golp.Entry {
+	return g.entryVal(1, source, sink...)
+}
+
+func (g *Graph) entryVal(val float64, source int, sink ...int) golp.Entry {
+	switch len(sink) {
+	case 0:
+		return golp.Entry{Col: source, Val: val}
+	case 1:
+		return golp.Entry{Col: g.edge(source, sink[0]), Val: val}
+	default:
+		panic("bad entry call")
+	}
+}
+
+func (g *Graph) edge(source, sink int) int {
+	if source == sink {
+		panic("source=sink")
+	}
+
+	nVertices := len(g.Vertices)
+
+	i := nVertices
+	i += source * (nVertices - 1)
+	if sink > source {
+		i += sink - 1
+	} else {
+		i += sink
+	}
+
+	return i
+}
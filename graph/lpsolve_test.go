@@ -0,0 +1,43 @@
+//go:build cgo && !purego
+
+package graph
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+// usesLPSolve is whether Graph is the lpsolve MILP in this build.
+const usesLPSolve = true
+
+func TestEdge(t *testing.T) {
+	g := &Graph{Vertices: []string{"A", "B", "C"}}
+	a, b, c := 0, 1, 2
+
+	// cols - A, B, C, AB, AC, BA, BC, CA, CB
+	//        0  1  2  3   4   5   6   7   8
+
+	assert.Equal(t, 3, g.edge(a, b))
+	assert.Equal(t, 4, g.edge(a, c))
+	assert.Equal(t, 5, g.edge(b, a))
+	assert.Equal(t, 6, g.edge(b, c))
+	assert.Equal(t, 7, g.edge(c, a))
+	assert.Equal(t, 8, g.edge(c, b))
+}
+
+func TestSolveDone(t *testing.T) {
+	vertices, edgeCosts, weights := testData(5)
+	g, err := NewGraph(vertices, edgeCosts)
+	assert.NoError(t, err)
+
+	// solves don't start once ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = g.Solve(ctx, 2, weights)
+	assert.IsError(t, err, context.Canceled)
+
+	_, _, err = g.Solve(context.Background(), 2, weights)
+	assert.NoError(t, err)
+}
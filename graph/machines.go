@@ -0,0 +1,62 @@
+package graph
+
+import "sort"
+
+// Allocation is how many machines a sink gets for the weight it serves.
+type Allocation struct {
+	Sink     string
+	Weight   float64
+	Machines int
+}
+
+// AllocateMachines splits machines between the sinks in assignments, as
+// returned by Assign. Each sink first gets enough machines that none serves
+// more than machineCapacity, a share of the total weight as with WithMachines,
+// and at least one. The rest go one at a time to the sink with the most weight
+// per machine, so machines are loaded as evenly as possible. Allocations are in
+// sink order. It returns ErrInfeasible if there aren't enough machines, which
+// can't happen if Assign was given the same WithMachines option.
+func AllocateMachines(assignments []Assignment, machines int, machineCapacity float64) ([]Allocation, error) {
+	var (
+		bySink = map[string]*Allocation{}
+		ret    []*Allocation
+		total  float64
+	)
+	for _, a := range assignments {
+		alloc, ok := bySink[a.Sink]
+		if !ok {
+			alloc = &Allocation{Sink: a.Sink}
+			bySink[a.Sink] = alloc
+			ret = append(ret, alloc)
+		}
+		alloc.Weight += a.Weight
+		total += a.Weight
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Sink < ret[j].Sink })
+
+	remaining := machines
+	for _, alloc := range ret {
+		alloc.Machines = machinesNeeded(alloc.Weight, machineCapacity*total)
+		remaining -= alloc.Machines
+	}
+	if remaining < 0 || len(ret) == 0 {
+		return nil, ErrInfeasible
+	}
+
+	for ; remaining > 0; remaining-- {
+		busiest := ret[0]
+		for _, alloc := range ret[1:] {
+			if alloc.Weight/float64(alloc.Machines) > busiest.Weight/float64(busiest.Machines) {
+				busiest = alloc
+			}
+		}
+		busiest.Machines++
+	}
+
+	allocs := make([]Allocation, len(ret))
+	for i, alloc := range ret {
+		allocs[i] = *alloc
+	}
+
+	return allocs, nil
+}
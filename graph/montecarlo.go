@@ -0,0 +1,195 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+)
+
+// MonteCarlo solves many randomly perturbed copies of a problem, to show how
+// sensitive the solution is to noise in the vertex weights and edge costs.
+type MonteCarlo struct {
+	// Trials is how many perturbed problems to solve, on Workers goroutines.
+	// Workers defaults to GOMAXPROCS.
+	Trials, Workers int
+
+	// WeightNoise and CostNoise scale each vertex weight and edge cost by a
+	// random factor within ±WeightNoise and ±CostNoise, e.g. 0.1 for ±10%.
+	WeightNoise, CostNoise float64
+
+	// CostStdDevs, if set, is the standard deviation of each edge cost, in the
+	// same shape as the edge costs. Normally distributed noise with these
+	// deviations is added to edge costs.
+	CostStdDevs [][]float64
+
+	// Seed seeds the noise. Trials with the same seed are perturbed the same
+	// way, regardless of Workers.
+	Seed int64
+}
+
+// NewSolverFunc returns a Solver for a perturbed problem.
+type NewSolverFunc func(vertices []string, edgeCosts [][]float64) (Solver, error)
+
+// Run solves each trial for k sinks with a solver from newSolver, returning a
+// solution for each trial in order. Costs are of the perturbed problem.
+// Solutions for trials that can't satisfy the constraints have no picks and
+// infinite cost. If ctx is done before every trial is solved, solutions for
+// the trials finished so far are returned with an error wrapping
+// ErrIncomplete and ctx's error.
+func (mc MonteCarlo) Run(ctx context.Context, newSolver NewSolverFunc, vertices []string, edgeCosts [][]float64, k int, vertexWeights []float64, opts ...Option) ([]Solution, error) {
+	if err := mc.validate(edgeCosts); err != nil {
+		return nil, err
+	}
+
+	workers := mc.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		trials    = make(chan int)
+		solutions = make([]Solution, mc.Trials)
+		solved    = make([]bool, mc.Trials)
+		errs      = make([]error, workers)
+		wg        sync.WaitGroup
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for trial := range trials {
+				sol, err := mc.trial(ctx, trial, newSolver, vertices, edgeCosts, k, vertexWeights, opts)
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					errs[w] = err
+					cancel()
+					return
+				}
+				solutions[trial], solved[trial] = sol, true
+			}
+		}(w)
+	}
+
+sendLoop:
+	for trial := 0; trial < mc.Trials; trial++ {
+		select {
+		case trials <- trial:
+		case <-ctx.Done():
+			break sendLoop
+		}
+	}
+	close(trials)
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	ret := make([]Solution, 0, mc.Trials)
+	for trial, sol := range solutions {
+		if solved[trial] {
+			ret = append(ret, sol)
+		}
+	}
+	if len(ret) < mc.Trials {
+		return ret, incomplete(ctx)
+	}
+
+	return ret, nil
+}
+
+func (mc MonteCarlo) validate(edgeCosts [][]float64) error {
+	switch {
+	case mc.Trials <= 0:
+		return errors.New("trials must be positive")
+	case !(mc.WeightNoise >= 0 && mc.WeightNoise <= 1):
+		return fmt.Errorf("weight noise must be between 0 and 1, not %v", mc.WeightNoise)
+	case !(mc.CostNoise >= 0 && mc.CostNoise <= 1):
+		return fmt.Errorf("cost noise must be between 0 and 1, not %v", mc.CostNoise)
+	}
+
+	if mc.CostStdDevs != nil {
+		if len(mc.CostStdDevs) != len(edgeCosts) {
+			return errors.New("cost std devs must be the same shape as edge costs")
+		}
+		for i, row := range mc.CostStdDevs {
+			if len(row) != len(edgeCosts[i]) {
+				return errors.New("cost std devs must be the same shape as edge costs")
+			}
+		}
+	}
+
+	return nil
+}
+
+// trialSeed derives a trial's seed from the run's. Seeds are mixed with
+// SplitMix64 rather than added, so runs with nearby seeds don't share trials.
+func trialSeed(seed int64, trial int) int64 {
+	return int64(splitMix64(splitMix64(uint64(seed)) + uint64(trial)))
+}
+
+// splitMix64 is the SplitMix64 generator's output for state x.
+func splitMix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// trial solves one perturbed problem.
+func (mc MonteCarlo) trial(ctx context.Context, trial int, newSolver NewSolverFunc, vertices []string, edgeCosts [][]float64, k int, vertexWeights []float64, opts []Option) (Solution, error) {
+	rng := rand.New(rand.NewSource(trialSeed(mc.Seed, trial)))
+
+	noise := func(v, relative float64) float64 {
+		return v * (1 + relative*(2*rng.Float64()-1))
+	}
+
+	// keep the total weight the same, so costs are comparable between trials
+	var total, noisyTotal float64
+	weights := make([]float64, len(vertexWeights))
+	for i, w := range vertexWeights {
+		weights[i] = noise(w, mc.WeightNoise)
+		total += w
+		noisyTotal += weights[i]
+	}
+	if noisyTotal > 0 {
+		for i := range weights {
+			weights[i] *= total / noisyTotal
+		}
+	}
+
+	costs := make([][]float64, len(edgeCosts))
+	for i, row := range edgeCosts {
+		costs[i] = make([]float64, len(row))
+		for j, c := range row {
+			c = noise(c, mc.CostNoise)
+			if mc.CostStdDevs != nil {
+				c += rng.NormFloat64() * mc.CostStdDevs[i][j]
+			}
+			costs[i][j] = math.Max(0, c)
+		}
+	}
+
+	s, err := newSolver(vertices, costs)
+	if err != nil {
+		return Solution{}, err
+	}
+
+	cost, picks, err := s.Solve(ctx, k, weights, opts...)
+	if errors.Is(err, ErrInfeasible) {
+		return Solution{Cost: math.Inf(1)}, nil
+	} else if err != nil {
+		return Solution{}, err
+	}
+
+	return Solution{Cost: cost, Picks: picks}, nil
+}
@@ -0,0 +1,355 @@
+package graph
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Option configures a call to Solver.Solve.
+type Option func(*options)
+
+// Objective is what a solver minimizes.
+type Objective int
+
+const (
+	// ObjectiveSum minimizes the total weighted cost of serving every vertex,
+	// which is the average cost if weights sum to 1.
+	ObjectiveSum Objective = iota
+
+	// ObjectiveMax minimizes the largest cost of serving any vertex with
+	// weight. Vertices without weight are ignored.
+	ObjectiveMax
+
+	// ObjectiveMaxWeighted minimizes the largest weighted cost of serving any
+	// vertex.
+	ObjectiveMaxWeighted
+)
+
+// Failover is how the cost of a combination accounts for any one of its sinks
+// failing.
+type Failover int
+
+const (
+	// FailoverNone ignores sink failures.
+	FailoverNone Failover = iota
+
+	// FailoverExpected adds the average cost over each sink failing in turn.
+	FailoverExpected
+
+	// FailoverWorst adds the cost of the sink whose failure costs the most.
+	FailoverWorst
+)
+
+type options struct {
+	// max share of total vertex weight each vertex can serve as a sink.
+	capacities []float64
+
+	objective Objective
+
+	// max cost of serving a vertex with weight.
+	maxCost float64
+
+	// vertices that must or mustn't be sinks.
+	required, excluded []string
+
+	// how much the cost with one sink failed counts towards the objective.
+	failover       Failover
+	failoverWeight float64
+
+	// fixed cost of each vertex being a sink, the most that all the sinks can
+	// cost, and how much their cost counts towards the objective.
+	sinkCosts      []float64
+	budget         float64
+	sinkCostWeight float64
+
+	// machines to split between the sinks, or 0 if they aren't limited, and
+	// the share of total vertex weight each can serve.
+	machines        int
+	machineCapacity float64
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{maxCost: math.Inf(1), budget: math.Inf(1)}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithCapacities limits the share of the total vertex weight that each vertex
+// can serve if it's chosen as a sink, including its own weight. Capacities
+// correspond to the solver's vertices. Capacities of 1 or more (or +Inf) are
+// unlimited. A vertex whose own weight exceeds its capacity can't be a sink.
+func WithCapacities(capacities []float64) Option {
+	return func(o *options) {
+		o.capacities = capacities
+	}
+}
+
+// WithObjective sets what the solver minimizes. The default is ObjectiveSum.
+// For minimax objectives, the returned cost is the largest cost and ties are
+// broken by the total weighted cost.
+func WithObjective(objective Objective) Option {
+	return func(o *options) {
+		o.objective = objective
+	}
+}
+
+// WithMaxCost requires every vertex with weight to be served by a sink that
+// costs no more than maxCost. Costs are unweighted.
+func WithMaxCost(maxCost float64) Option {
+	return func(o *options) {
+		o.maxCost = maxCost
+	}
+}
+
+// WithRequired requires the named vertices to be chosen as sinks. They count
+// towards k.
+func WithRequired(vertices ...string) Option {
+	return func(o *options) {
+		o.required = append(o.required, vertices...)
+	}
+}
+
+// WithExcluded prevents the named vertices from being chosen as sinks. They're
+// still served by other sinks.
+func WithExcluded(vertices ...string) Option {
+	return func(o *options) {
+		o.excluded = append(o.excluded, vertices...)
+	}
+}
+
+// WithFailover makes the cost of a combination account for any one of its
+// sinks failing. Each vertex has a backup: the nearest sink other than the one
+// serving it. When a sink fails, the vertices it served are served by their
+// backups instead, and the cost with the failed sink is scored under the same
+// objective. The cost of a combination is its cost with every sink up, plus
+// weight times the expected or worst cost with one sink failed. Combinations
+// need at least 2 sinks. Backups aren't limited by capacities or the max cost.
+func WithFailover(failover Failover, weight float64) Option {
+	return func(o *options) {
+		o.failover = failover
+		o.failoverWeight = weight
+	}
+}
+
+// WithSinkCosts sets the fixed cost of choosing each vertex as a sink, such as
+// the price of running servers there. Costs correspond to the solver's
+// vertices and must be non-negative. They only matter with WithBudget or
+// WithSinkCostWeight.
+func WithSinkCosts(costs []float64) Option {
+	return func(o *options) {
+		o.sinkCosts = costs
+	}
+}
+
+// WithBudget requires the total sink cost of a combination to be no more than
+// budget.
+func WithBudget(budget float64) Option {
+	return func(o *options) {
+		o.budget = budget
+	}
+}
+
+// WithSinkCostWeight adds weight times the total sink cost of a combination to
+// its cost.
+func WithSinkCostWeight(weight float64) Option {
+	return func(o *options) {
+		o.sinkCostWeight = weight
+	}
+}
+
+// WithMachines requires the sinks to run on no more than machines machines,
+// each serving no more than machineCapacity, a share of the total vertex weight
+// like WithCapacities. Every sink needs at least one machine, so combinations
+// can't have more sinks than machines. See AllocateMachines for splitting the
+// machines between a combination's sinks.
+func WithMachines(machines int, machineCapacity float64) Option {
+	return func(o *options) {
+		o.machines = machines
+		o.machineCapacity = machineCapacity
+	}
+}
+
+// vertexSets resolves required and excluded vertex names to masks over
+// vertices.
+func (o *options) vertexSets(vertices []string) (required, excluded []bool, err error) {
+	vmap := make(map[string]int, len(vertices))
+	for i, v := range vertices {
+		vmap[v] = i
+	}
+
+	required = make([]bool, len(vertices))
+	excluded = make([]bool, len(vertices))
+
+	for _, v := range o.required {
+		i, ok := vmap[v]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown vertex %q", v)
+		}
+		required[i] = true
+	}
+
+	for _, v := range o.excluded {
+		i, ok := vmap[v]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown vertex %q", v)
+		}
+		if required[i] {
+			return nil, nil, fmt.Errorf("vertex %q is both required and excluded", v)
+		}
+		excluded[i] = true
+	}
+
+	return required, excluded, nil
+}
+
+// assignmentCost is the cost of a vertex being served at cost, as counted by
+// minimax objectives.
+func (o *options) assignmentCost(cost, weight float64) float64 {
+	switch {
+	case o.objective == ObjectiveMaxWeighted:
+		return cost * weight
+	case weight > 0:
+		return cost
+	default:
+		return 0
+	}
+}
+
+// sinkCost returns the total fixed cost of the sinks in combo.
+func (o *options) sinkCost(combo []int) float64 {
+	var c float64
+	for _, sink := range combo {
+		c += o.vertexSinkCost(sink)
+	}
+	return c
+}
+
+func (o *options) vertexSinkCost(vertex int) float64 {
+	if o.sinkCosts == nil {
+		return 0
+	}
+	return o.sinkCosts[vertex]
+}
+
+// withinBudget returns true if sinks costing sinkCost are within budget.
+func (o *options) withinBudget(sinkCost float64) bool {
+	return sinkCost <= o.budget+1e-9*math.Max(1, math.Abs(o.budget))
+}
+
+// capacity returns the absolute weight that vertex can serve, or +Inf.
+func (o *options) capacity(vertex int, totalWeight float64) float64 {
+	if o.capacities == nil || o.capacities[vertex] >= 1 || math.IsNaN(o.capacities[vertex]) {
+		return math.Inf(1)
+	}
+	return o.capacities[vertex] * totalWeight
+}
+
+// sinkMachines returns how many machines a sink serving weight needs, or 0 if
+// machines aren't limited.
+func (o *options) sinkMachines(weight, totalWeight float64) int {
+	if o.machines == 0 {
+		return 0
+	}
+	return machinesNeeded(weight, o.machineCapacity*totalWeight)
+}
+
+// machinesNeeded returns how many machines serving no more than capacity each
+// it takes to serve weight, and at least one.
+func machinesNeeded(weight, capacity float64) int {
+	if weight <= 0 {
+		return 1
+	}
+	// tolerate rounding in weights that exactly fill their machines
+	return int(math.Max(1, math.Ceil(weight/capacity-1e-9)))
+}
+
+func (o *options) capacitated() bool {
+	if o.machines > 0 {
+		return true
+	}
+	for _, c := range o.capacities {
+		if c < 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// enoughCapacity returns false if no k sinks have the capacity, or the
+// machines, to serve all of vertexWeights, so solvers can reject the instance
+// without searching every combination's assignments.
+func (o *options) enoughCapacity(k int, vertexWeights []float64) bool {
+	total := sum(vertexWeights)
+	tolerance := 1e-9 * total
+
+	if o.machines > 0 && (k > o.machines || float64(o.machines)*o.machineCapacity*total < total-tolerance) {
+		return false
+	}
+
+	capacities := make([]float64, len(vertexWeights))
+	for v := range capacities {
+		capacities[v] = o.capacity(v, total)
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(capacities)))
+	if k < len(capacities) {
+		capacities = capacities[:k]
+	}
+
+	return sum(capacities) >= total-tolerance
+}
+
+func sum(vals []float64) float64 {
+	var s float64
+	for _, v := range vals {
+		s += v
+	}
+	return s
+}
+
+// fullMatrix returns the directed edge costs, where ret[source][sink] is the
+// cost of source being served by sink. edgeCosts are either already directed,
+// with n rows of n costs, or the lower triangle of a symmetric matrix. The
+// diagonal is always 0.
+func fullMatrix(n int, edgeCosts [][]float64) [][]float64 {
+	ret := make([][]float64, n)
+	for i := range ret {
+		ret[i] = make([]float64, n)
+	}
+
+	if isDirected(n, edgeCosts) {
+		for source, row := range edgeCosts {
+			for sink, v := range row {
+				if sink != source {
+					ret[source][sink] = v
+				}
+			}
+		}
+		return ret
+	}
+
+	for i, row := range edgeCosts {
+		a := i + 1
+		for b, v := range row {
+			ret[a][b] = v
+			ret[b][a] = v
+		}
+	}
+	return ret
+}
+
+// isDirected returns whether edgeCosts is a full n by n matrix rather than a
+// lower triangle.
+func isDirected(n int, edgeCosts [][]float64) bool {
+	if len(edgeCosts) != n {
+		return false
+	}
+	for _, row := range edgeCosts {
+		if len(row) != n {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,13 @@
+//go:build purego || !cgo
+
+package graph
+
+// Graph is BranchAndBound in builds with the purego tag or without cgo, which
+// can't use lpsolve.
+type Graph = BranchAndBound
+
+// NewGraph returns a BranchAndBound solver for builds without lpsolve. Edge
+// costs are specified the same way as for the lpsolve Graph.
+func NewGraph(vertices []string, edgeCosts [][]float64) (*Graph, error) {
+	return NewBranchAndBound(vertices, edgeCosts), nil
+}
@@ -0,0 +1,6 @@
+//go:build purego || !cgo
+
+package graph
+
+// usesLPSolve is whether Graph is the lpsolve MILP in this build.
+const usesLPSolve = false
@@ -0,0 +1,43 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"math"
+)
+
+// SweepSolver is a Solver that can reuse work between solves for a range of k.
+type SweepSolver interface {
+	Solver
+
+	// Sweep returns a solution for each k from kMin to kMax. Solutions for k
+	// that can't satisfy the constraints have no picks and infinite cost. If
+	// ctx is done before the solver finishes, solutions for the k solved so far
+	// are returned with an error wrapping ErrIncomplete and ctx's error.
+	Sweep(ctx context.Context, kMin, kMax int, vertexWeights []float64, opts ...Option) ([]Solution, error)
+}
+
+// Sweep solves for each k from kMin to kMax with s, as described by
+// SweepSolver. Solvers that aren't SweepSolvers solve for each k in turn.
+func Sweep(ctx context.Context, s Solver, kMin, kMax int, vertexWeights []float64, opts ...Option) ([]Solution, error) {
+	if ss, ok := s.(SweepSolver); ok {
+		return ss.Sweep(ctx, kMin, kMax, vertexWeights, opts...)
+	}
+
+	ret := make([]Solution, 0, kMax-kMin+1)
+	for k := kMin; k <= kMax; k++ {
+		cost, picks, err := s.Solve(ctx, k, vertexWeights, opts...)
+		switch {
+		case errors.Is(err, ErrInfeasible):
+			ret = append(ret, Solution{Cost: math.Inf(1)})
+			continue
+		case ctx.Err() != nil:
+			return ret, incomplete(ctx)
+		case err != nil:
+			return nil, err
+		}
+		ret = append(ret, Solution{Cost: cost, Picks: picks})
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,337 @@
+package regions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const historyVersion = 1
+
+type resolution struct {
+	name      string
+	step      time.Duration
+	retention int // number of buckets kept
+}
+
+// resolutions that history is downsampled into, finest first.
+var resolutions = []resolution{
+	{"1m", time.Minute, 6 * 60},
+	{"1h", time.Hour, 7 * 24},
+	{"1d", 24 * time.Hour, 90},
+}
+
+// History keeps a downsampled time series of latency between every pair of
+// regions.
+type History struct {
+	pairs map[historyPair]*pairHistory
+	m     sync.RWMutex
+}
+
+type historyPair struct {
+	src, dst string
+}
+
+type pairHistory struct {
+	// buckets for each resolution, oldest first.
+	buckets [][]bucket
+}
+
+type bucket struct {
+	Start int64 `json:"start"` // unix seconds
+	Count int32 `json:"count"`
+	Sum   int32 `json:"sum"`
+	Min   int32 `json:"min"`
+	Max   int32 `json:"max"`
+}
+
+// HistoryPoint is a bucket of latency samples in milliseconds.
+type HistoryPoint struct {
+	Time    time.Time `json:"time"`
+	Mean    float64   `json:"mean"`
+	Min     int       `json:"min"`
+	Max     int       `json:"max"`
+	Samples int       `json:"samples"`
+}
+
+type HistorySeries struct {
+	Src    string         `json:"src"`
+	Dst    string         `json:"dst"`
+	Points []HistoryPoint `json:"points"`
+}
+
+type HistoryQuery struct {
+	Src, Dst   string // empty matches all regions
+	From, To   time.Time
+	Resolution string // empty picks the finest resolution covering From
+}
+
+func NewHistory() *History {
+	return &History{pairs: map[historyPair]*pairHistory{}}
+}
+
+// Record adds a sample for every pair in latencies. Unknown latencies
+// (math.MaxInt) are skipped.
+func (h *History) Record(latencies map[string]map[string]int, t time.Time) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	for src, dsts := range latencies {
+		for dst, latency := range dsts {
+			if latency == math.MaxInt || latency < 0 {
+				continue
+			}
+
+			hp := historyPair{src, dst}
+			ph, ok := h.pairs[hp]
+			if !ok {
+				ph = &pairHistory{buckets: make([][]bucket, len(resolutions))}
+				h.pairs[hp] = ph
+			}
+
+			ph.record(latency, t)
+		}
+	}
+}
+
+func (ph *pairHistory) record(latency int, t time.Time) {
+	l := int32(latency)
+
+	for i, res := range resolutions {
+		start := t.Truncate(res.step).Unix()
+		buckets := ph.buckets[i]
+
+		if n := len(buckets); n > 0 && buckets[n-1].Start == start {
+			b := &buckets[n-1]
+			b.Count++
+			b.Sum += l
+			if l < b.Min {
+				b.Min = l
+			}
+			if l > b.Max {
+				b.Max = l
+			}
+			continue
+		}
+
+		b := bucket{Start: start, Count: 1, Sum: l, Min: l, Max: l}
+		if len(buckets) < res.retention {
+			ph.buckets[i] = append(buckets, b)
+		} else {
+			copy(buckets, buckets[1:])
+			buckets[len(buckets)-1] = b
+		}
+	}
+}
+
+// Query returns the series matching q, sorted by source and destination.
+func (h *History) Query(q HistoryQuery) ([]HistorySeries, error) {
+	ri, err := q.resolution()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		from, to = q.From.Unix(), q.To.Unix()
+		step     = int64(resolutions[ri].step / time.Second)
+	)
+
+	h.m.RLock()
+	defer h.m.RUnlock()
+
+	ret := []HistorySeries{}
+	for hp, ph := range h.pairs {
+		if (q.Src != "" && q.Src != hp.src) || (q.Dst != "" && q.Dst != hp.dst) {
+			continue
+		}
+
+		hs := HistorySeries{Src: hp.src, Dst: hp.dst, Points: []HistoryPoint{}}
+		for _, b := range ph.buckets[ri] {
+			if b.Start+step <= from || b.Start > to {
+				continue
+			}
+			hs.Points = append(hs.Points, HistoryPoint{
+				Time:    time.Unix(b.Start, 0).UTC(),
+				Mean:    float64(b.Sum) / float64(b.Count),
+				Min:     int(b.Min),
+				Max:     int(b.Max),
+				Samples: int(b.Count),
+			})
+		}
+
+		if len(hs.Points) > 0 {
+			ret = append(ret, hs)
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Src != ret[j].Src {
+			return ret[i].Src < ret[j].Src
+		}
+		return ret[i].Dst < ret[j].Dst
+	})
+
+	return ret, nil
+}
+
+// ParseHistoryQuery parses the src, dst, from, to and resolution query
+// parameters. Times are RFC 3339 or unix seconds. from defaults to a day
+// before to, which defaults to now.
+func ParseHistoryQuery(params url.Values) (HistoryQuery, error) {
+	q := HistoryQuery{
+		Src:        params.Get("src"),
+		Dst:        params.Get("dst"),
+		To:         time.Now(),
+		Resolution: params.Get("resolution"),
+	}
+
+	if to := params.Get("to"); to != "" {
+		t, err := parseTime(to)
+		if err != nil {
+			return q, fmt.Errorf("bad to: %w", err)
+		}
+		q.To = t
+	}
+
+	q.From = q.To.Add(-24 * time.Hour)
+	if from := params.Get("from"); from != "" {
+		t, err := parseTime(from)
+		if err != nil {
+			return q, fmt.Errorf("bad from: %w", err)
+		}
+		q.From = t
+	}
+
+	if _, err := q.resolution(); err != nil {
+		return q, err
+	}
+
+	return q, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if unix, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func (q HistoryQuery) resolution() (int, error) {
+	if q.To.Before(q.From) {
+		return 0, errors.New("to is before from")
+	}
+
+	if q.Resolution != "" {
+		for i, res := range resolutions {
+			if res.name == q.Resolution {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("unknown resolution %q", q.Resolution)
+	}
+
+	since := time.Since(q.From)
+	for i, res := range resolutions {
+		if since <= res.step*time.Duration(res.retention) {
+			return i, nil
+		}
+	}
+
+	return len(resolutions) - 1, nil
+}
+
+type historySnapshot struct {
+	Version int                   `json:"version"`
+	Pairs   []pairHistorySnapshot `json:"pairs"`
+}
+
+type pairHistorySnapshot struct {
+	Src     string              `json:"src"`
+	Dst     string              `json:"dst"`
+	Buckets map[string][]bucket `json:"buckets"`
+}
+
+// Save writes the history to path. The file is replaced atomically.
+func (h *History) Save(path string) error {
+	h.m.RLock()
+	snap := historySnapshot{Version: historyVersion, Pairs: make([]pairHistorySnapshot, 0, len(h.pairs))}
+	for hp, ph := range h.pairs {
+		phs := pairHistorySnapshot{Src: hp.src, Dst: hp.dst, Buckets: make(map[string][]bucket, len(resolutions))}
+		for i, res := range resolutions {
+			phs.Buckets[res.name] = ph.buckets[i]
+		}
+		snap.Pairs = append(snap.Pairs, phs)
+	}
+	j, err := json.Marshal(snap)
+	h.m.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, j)
+}
+
+// Load replaces the history with one written by Save. A missing file isn't an
+// error. Corrupt or unsupported files are ignored and an error is returned.
+func (h *History) Load(path string) error {
+	j, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var snap historySnapshot
+	if err := json.Unmarshal(j, &snap); err != nil {
+		return fmt.Errorf("corrupt history: %w", err)
+	}
+	if snap.Version != historyVersion {
+		return fmt.Errorf("unsupported history version %d", snap.Version)
+	}
+
+	pairs := make(map[historyPair]*pairHistory, len(snap.Pairs))
+	for _, phs := range snap.Pairs {
+		ph := &pairHistory{buckets: make([][]bucket, len(resolutions))}
+		for i, res := range resolutions {
+			buckets := phs.Buckets[res.name]
+			if err := validBuckets(buckets); err != nil {
+				return fmt.Errorf("corrupt history: %s->%s %s: %w", phs.Src, phs.Dst, res.name, err)
+			}
+			if len(buckets) > res.retention {
+				buckets = buckets[len(buckets)-res.retention:]
+			}
+			ph.buckets[i] = buckets
+		}
+		pairs[historyPair{phs.Src, phs.Dst}] = ph
+	}
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.pairs = pairs
+
+	return nil
+}
+
+// validBuckets checks that buckets hold samples and are oldest first.
+func validBuckets(buckets []bucket) error {
+	for i, b := range buckets {
+		switch {
+		case b.Count <= 0:
+			return fmt.Errorf("bucket at %d has no samples", b.Start)
+		case b.Min > b.Max:
+			return fmt.Errorf("bucket at %d has min %d above max %d", b.Start, b.Min, b.Max)
+		case i > 0 && b.Start <= buckets[i-1].Start:
+			return fmt.Errorf("bucket at %d is out of order", b.Start)
+		}
+	}
+	return nil
+}
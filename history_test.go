@@ -0,0 +1,100 @@
+package regions
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestHistory(t *testing.T) {
+	h := NewHistory()
+	start := time.Now().Truncate(24 * time.Hour).Add(time.Hour)
+
+	for i := 0; i < 120; i++ {
+		h.Record(map[string]map[string]int{
+			"iad": {"fra": 80 + i%3},
+			"fra": {"iad": 90},
+		}, start.Add(time.Duration(i)*30*time.Second))
+	}
+
+	series, err := h.Query(HistoryQuery{Src: "iad", From: start, To: start.Add(time.Hour), Resolution: "1m"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(series))
+	assert.Equal(t, "fra", series[0].Dst)
+	assert.Equal(t, 60, len(series[0].Points))
+	assert.Equal(t, HistoryPoint{Time: start.UTC(), Mean: 80.5, Min: 80, Max: 81, Samples: 2}, series[0].Points[0])
+
+	series, err = h.Query(HistoryQuery{From: start, To: start.Add(time.Hour), Resolution: "1h"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(series))
+	assert.Equal(t, "fra", series[0].Src)
+	assert.Equal(t, []HistoryPoint{{Time: start.UTC(), Mean: 90, Min: 90, Max: 90, Samples: 120}}, series[0].Points)
+	assert.Equal(t, "iad", series[1].Src)
+	assert.Equal(t, 81.0, series[1].Points[0].Mean)
+
+	// window after all samples
+	series, err = h.Query(HistoryQuery{From: start.Add(2 * time.Hour), To: start.Add(3 * time.Hour), Resolution: "1m"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(series))
+
+	_, err = h.Query(HistoryQuery{From: start, To: start, Resolution: "1s"})
+	assert.Error(t, err)
+
+	t.Run("retention", func(t *testing.T) {
+		h := NewHistory()
+		for i := 0; i < resolutions[0].retention+10; i++ {
+			h.Record(map[string]map[string]int{"iad": {"fra": 80}}, start.Add(time.Duration(i)*time.Minute))
+		}
+		assert.Equal(t, resolutions[0].retention, len(h.pairs[historyPair{"iad", "fra"}].buckets[0]))
+	})
+
+	t.Run("persistence", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "history.json")
+		assert.NoError(t, h.Save(path))
+
+		loaded := NewHistory()
+		assert.NoError(t, loaded.Load(path))
+		assert.Equal(t, h.pairs, loaded.pairs)
+
+		for _, buckets := range []string{
+			`[{"start":60,"count":0,"sum":0,"min":0,"max":0}]`,
+			`[{"start":60,"count":1,"sum":80,"min":90,"max":80}]`,
+			`[{"start":120,"count":1,"sum":80,"min":80,"max":80},{"start":60,"count":1,"sum":80,"min":80,"max":80}]`,
+		} {
+			assert.NoError(t, os.WriteFile(path, []byte(`{"version":1,"pairs":[{"src":"iad","dst":"fra","buckets":{"1m":`+buckets+`}}]}`), 0644))
+			err := loaded.Load(path)
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), "corrupt history")
+		}
+		assert.Equal(t, h.pairs, loaded.pairs)
+	})
+}
+
+func TestParseHistoryQuery(t *testing.T) {
+	q, err := ParseHistoryQuery(url.Values{
+		"src":        {"iad"},
+		"dst":        {"fra"},
+		"from":       {"2023-07-20T00:00:00Z"},
+		"to":         {"1689897600"},
+		"resolution": {"1h"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "iad", q.Src)
+	assert.Equal(t, "fra", q.Dst)
+	assert.True(t, q.From.Equal(time.Date(2023, 7, 20, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, q.To.Equal(time.Date(2023, 7, 21, 0, 0, 0, 0, time.UTC)))
+
+	q, err = ParseHistoryQuery(url.Values{})
+	assert.NoError(t, err)
+	assert.Equal(t, 24*time.Hour, q.To.Sub(q.From))
+
+	_, err = ParseHistoryQuery(url.Values{"from": {"yesterday"}})
+	assert.Error(t, err)
+
+	_, err = ParseHistoryQuery(url.Values{"from": {"1689897600"}, "to": {"1689811200"}})
+	assert.Error(t, err)
+}
@@ -1,35 +1,143 @@
 package regions
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"math"
-	"net/http"
-	"net/http/httptrace"
+	"net"
 	"sync"
 	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// LatencyStats summarizes the samples in a LatencyTracker's window. All values
+// are in milliseconds.
+type LatencyStats struct {
+	SMA     int `json:"sma"`
+	P50     int `json:"p50"`
+	P90     int `json:"p90"`
+	P99     int `json:"p99"`
+	Min     int `json:"min"`
+	Max     int `json:"max"`
+	Jitter  int `json:"jitter"`
+	Samples int `json:"samples"`
+
+	Probes ProbeStats `json:"probes"`
+	Status PeerStatus `json:"status,omitempty"`
+}
+
+// ProbeStats counts probe outcomes over a LatencyTracker's window.
+type ProbeStats struct {
+	Successes   int       `json:"successes"`
+	Failures    int       `json:"failures"`
+	Timeouts    int       `json:"timeouts"`
+	LossRate    float64   `json:"loss_rate"`
+	LastSuccess time.Time `json:"last_success"`
+}
+
+type PeerStatus string
+
+const (
+	PeerOK          PeerStatus = "ok"
+	PeerStale       PeerStatus = "stale"
+	PeerUnreachable PeerStatus = "unreachable"
 )
 
+const (
+	// a peer is stale after this many intervals without a successful probe.
+	staleIntervals = 3
+
+	// a peer is unreachable after this many intervals without a successful
+	// probe, or if it has never been successfully probed.
+	unreachableIntervals = 10
+
+	// probers that can't fetch the peer's latency report fetch it over HTTP
+	// every this many intervals, so they mostly measure their own transport.
+	reportIntervals = 5
+)
+
+type probeResult uint8
+
+const (
+	probeSuccess probeResult = iota
+	probeFailure
+	probeTimeout
+)
+
+var probeResultNames = []string{
+	probeSuccess: "success",
+	probeFailure: "failure",
+	probeTimeout: "timeout",
+}
+
+// MarshalText encodes probe results by name, so they're readable in
+// snapshots.
+func (pr probeResult) MarshalText() ([]byte, error) {
+	if int(pr) >= len(probeResultNames) {
+		return nil, fmt.Errorf("unknown probe result %d", pr)
+	}
+	return []byte(probeResultNames[pr]), nil
+}
+
+func (pr *probeResult) UnmarshalText(text []byte) error {
+	i := slices.Index(probeResultNames, string(text))
+	if i < 0 {
+		return fmt.Errorf("unknown probe result %q", text)
+	}
+	*pr = probeResult(i)
+	return nil
+}
+
+// stats for a peer that only reported its SMA (schema v1).
+func statsFromLatency(ms int) LatencyStats {
+	return LatencyStats{SMA: ms, P50: ms, P90: ms, P99: ms, Min: ms, Max: ms}
+}
+
 type LatencyTracker struct {
-	url           string
+	prober        Prober
+	report        *HTTPProber
 	smaWindow     int
 	sma           time.Duration
 	smaPos        int
 	smaData       []time.Duration
-	hostLatencies map[string]int
+	hostLatencies map[string]LatencyStats
+	probePos      int
+	probeData     []probeResult
+	lastSuccess   time.Time
+	lastReport    time.Time
+	probeHist     *Histogram
+	failures      uint64
+	timeouts      uint64
 	interval      time.Duration
 	stop          chan struct{}
 	m             sync.RWMutex
 }
 
 func NewLatencyTracker(baseURL string, smaWindow int, interval time.Duration) *LatencyTracker {
+	return NewLatencyTrackerWithProber(baseURL, nil, smaWindow, interval)
+}
+
+// NewLatencyTrackerWithProber is like NewLatencyTracker, but measures round
+// trips with the given prober. The peer's latency report is still fetched over
+// HTTP from baseURL, every reportIntervals intervals. A nil prober measures
+// round trips over HTTP too, fetching the report with every probe.
+func NewLatencyTrackerWithProber(baseURL string, prober Prober, smaWindow int, interval time.Duration) *LatencyTracker {
+	report := &HTTPProber{URL: baseURL + versionedPath(LatencyPath, SchemaV2)}
+	if prober == nil {
+		prober = report
+	}
+
 	return &LatencyTracker{
-		url:       baseURL + LatencyPath,
+		prober:    prober,
+		report:    report,
 		smaWindow: smaWindow,
 		smaData:   make([]time.Duration, smaWindow),
+		probeData: make([]probeResult, smaWindow),
+		probeHist: NewHistogram(probeBuckets...),
 		interval:  interval,
 		stop:      make(chan struct{}),
 	}
@@ -72,45 +180,56 @@ func (lt *LatencyTracker) doRequest(ctx context.Context) error {
 	ctx, cancel := context.WithTimeout(ctx, lt.interval)
 	defer cancel()
 
-	// try to measure single round trip by looking at interval between
-	// finishing sending request and starting to read response.
-	var start, end time.Time
-	tctx := httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
-		WroteRequest:         func(wri httptrace.WroteRequestInfo) { start = time.Now() },
-		GotFirstResponseByte: func() { end = time.Now() },
-	})
+	if lp, ok := lt.prober.(latencyProber); ok {
+		rtt, hl, err := lp.ProbeLatency(ctx)
+		if err != nil {
+			lt.probeFailed(err)
+			return err
+		}
+		lt.update(rtt, hl)
+		return nil
+	}
 
-	req, err := http.NewRequestWithContext(tctx, http.MethodGet, lt.url, nil)
+	rtt, err := lt.prober.Probe(ctx)
 	if err != nil {
+		lt.probeFailed(err)
 		return err
 	}
+	lt.observe(rtt)
 
-	resp, err := http.DefaultClient.Do(req)
+	// non-HTTP probers can't fetch the peer's report. failing to fetch it
+	// doesn't fail the probe, and it's retried next interval.
+	if !lt.reportDue() {
+		return nil
+	}
+	_, hl, err := lt.report.ProbeLatency(ctx)
 	if err != nil {
-		return err
+		return fmt.Errorf("latency report: %w", err)
 	}
-	defer resp.Body.Close()
+	lt.setReport(hl)
 
-	hl := map[string]int{}
-	if err := json.NewDecoder(resp.Body).Decode(&hl); err != nil {
-		return err
-	}
+	return nil
+}
 
-	buf := new(bytes.Buffer)
-	if _, err := io.Copy(buf, resp.Body); err != nil {
-		return err
+func (lt *LatencyTracker) probeFailed(err error) {
+	if !errors.Is(err, context.Canceled) {
+		lt.fail(err)
 	}
+}
 
-	switch {
-	case start.IsZero():
-		return errors.New("zero start")
-	case end.IsZero():
-		return errors.New("zero end")
-	}
+// reportDue reports whether the peer's latency report should be fetched.
+func (lt *LatencyTracker) reportDue() bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.lastReport.IsZero() || time.Since(lt.lastReport) >= reportIntervals*lt.interval
+}
 
-	lt.update(end.Sub(start), hl)
+func (lt *LatencyTracker) setReport(hostLatencies map[string]LatencyStats) {
+	lt.m.Lock()
+	defer lt.m.Unlock()
 
-	return nil
+	lt.hostLatencies = hostLatencies
+	lt.lastReport = time.Now()
 }
 
 func (lt *LatencyTracker) Latency() int {
@@ -128,14 +247,180 @@ func (lt *LatencyTracker) Latencies() map[string]int {
 	lt.m.RLock()
 	defer lt.m.RUnlock()
 
+	if lt.hostLatencies == nil {
+		return nil
+	}
+
+	ret := make(map[string]int, len(lt.hostLatencies))
+	for host, stats := range lt.hostLatencies {
+		ret[host] = stats.SMA
+	}
+
+	return ret
+}
+
+// LatencyStats is like Latency, but includes percentiles, extremes and jitter
+// in addition to the SMA.
+func (lt *LatencyTracker) LatencyStats() LatencyStats {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+
+	samples := lt.samplesLocked()
+	if len(samples) == 0 {
+		return LatencyStats{
+			SMA: math.MaxInt,
+			P50: math.MaxInt,
+			P90: math.MaxInt,
+			P99: math.MaxInt,
+			Min: math.MaxInt,
+			Max: math.MaxInt,
+
+			Probes: lt.probeStatsLocked(),
+			Status: lt.statusLocked(),
+		}
+	}
+
+	var jitter time.Duration
+	for i := 1; i < len(samples); i++ {
+		if d := samples[i] - samples[i-1]; d < 0 {
+			jitter -= d
+		} else {
+			jitter += d
+		}
+	}
+	if len(samples) > 1 {
+		jitter /= time.Duration(len(samples) - 1)
+	}
+
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+
+	return LatencyStats{
+		SMA:     ms(lt.sma),
+		P50:     ms(percentile(sorted, 50)),
+		P90:     ms(percentile(sorted, 90)),
+		P99:     ms(percentile(sorted, 99)),
+		Min:     ms(sorted[0]),
+		Max:     ms(sorted[len(sorted)-1]),
+		Jitter:  ms(jitter),
+		Samples: len(samples),
+		Probes:  lt.probeStatsLocked(),
+		Status:  lt.statusLocked(),
+	}
+}
+
+// ProbeStats counts successful and failed probes over the window.
+func (lt *LatencyTracker) ProbeStats() ProbeStats {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.probeStatsLocked()
+}
+
+func (lt *LatencyTracker) probeStatsLocked() ProbeStats {
+	ps := ProbeStats{LastSuccess: lt.lastSuccess}
+
+	n := lt.probePos
+	if n > lt.smaWindow {
+		n = lt.smaWindow
+	}
+	for _, pr := range lt.probeData[:n] {
+		switch pr {
+		case probeSuccess:
+			ps.Successes++
+		case probeFailure:
+			ps.Failures++
+		case probeTimeout:
+			ps.Timeouts++
+		}
+	}
+	if n > 0 {
+		ps.LossRate = float64(ps.Failures+ps.Timeouts) / float64(n)
+	}
+
+	return ps
+}
+
+// Status reports whether the peer has been successfully probed recently.
+func (lt *LatencyTracker) Status() PeerStatus {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.statusLocked()
+}
+
+func (lt *LatencyTracker) statusLocked() PeerStatus {
+	if lt.lastSuccess.IsZero() {
+		return PeerUnreachable
+	}
+
+	switch since := time.Since(lt.lastSuccess); {
+	case since > unreachableIntervals*lt.interval:
+		return PeerUnreachable
+	case since > staleIntervals*lt.interval:
+		return PeerStale
+	default:
+		return PeerOK
+	}
+}
+
+// LatenciesStats is like Latencies, but includes the full stats reported by
+// the peer.
+func (lt *LatencyTracker) LatenciesStats() map[string]LatencyStats {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+
 	return lt.hostLatencies
 }
 
-func (lt *LatencyTracker) update(dur time.Duration, hostLatencies map[string]int) {
+// Percentile returns the pth percentile latency in milliseconds over the SMA
+// window.
+func (lt *LatencyTracker) Percentile(p float64) int {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+
+	samples := lt.samplesLocked()
+	if len(samples) == 0 {
+		return math.MaxInt
+	}
+	slices.Sort(samples)
+
+	return ms(percentile(samples, p))
+}
+
+func (lt *LatencyTracker) Min() int {
+	return lt.LatencyStats().Min
+}
+
+func (lt *LatencyTracker) Max() int {
+	return lt.LatencyStats().Max
+}
+
+// Jitter returns the mean difference in milliseconds between consecutive
+// samples.
+func (lt *LatencyTracker) Jitter() int {
+	return lt.LatencyStats().Jitter
+}
+
+// update records a successful probe and the latency report fetched with it.
+func (lt *LatencyTracker) update(dur time.Duration, hostLatencies map[string]LatencyStats) {
 	lt.m.Lock()
 	defer lt.m.Unlock()
 
 	lt.hostLatencies = hostLatencies
+	lt.lastReport = time.Now()
+	lt.observeLocked(dur)
+}
+
+// observe records a successful probe without a latency report.
+func (lt *LatencyTracker) observe(dur time.Duration) {
+	lt.m.Lock()
+	defer lt.m.Unlock()
+	lt.observeLocked(dur)
+}
+
+func (lt *LatencyTracker) observeLocked(dur time.Duration) {
+	lt.lastSuccess = time.Now()
+	lt.recordLocked(probeSuccess)
+	lt.probeHist.Observe(dur.Seconds())
 
 	lt.smaData[lt.smaPos%lt.smaWindow] = dur
 	lt.smaPos += 1
@@ -157,6 +442,99 @@ func (lt *LatencyTracker) nLocked() int {
 	return lt.smaWindow
 }
 
+func (lt *LatencyTracker) fail(err error) {
+	lt.m.Lock()
+	defer lt.m.Unlock()
+
+	var nerr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &nerr) && nerr.Timeout()) {
+		lt.recordLocked(probeTimeout)
+		lt.timeouts++
+	} else {
+		lt.recordLocked(probeFailure)
+		lt.failures++
+	}
+}
+
+func (lt *LatencyTracker) recordLocked(pr probeResult) {
+	lt.probeData[lt.probePos%lt.smaWindow] = pr
+	lt.probePos += 1
+}
+
+// probeErrors returns the number of failed and timed out probes since the
+// tracker was created.
+func (lt *LatencyTracker) probeErrors() (failures, timeouts uint64) {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.failures, lt.timeouts
+}
+
+// probesLocked returns a copy of the window's probe results, oldest first.
+func (lt *LatencyTracker) probesLocked() []probeResult {
+	if lt.probePos > lt.smaWindow {
+		start := lt.probePos % lt.smaWindow
+		return append(append([]probeResult{}, lt.probeData[start:]...), lt.probeData[:start]...)
+	}
+	return append([]probeResult{}, lt.probeData[:lt.probePos]...)
+}
+
+// samplesLocked returns a copy of the window's samples, oldest first.
+func (lt *LatencyTracker) samplesLocked() []time.Duration {
+	n := lt.nLocked()
+	ret := make([]time.Duration, 0, n)
+	if lt.smaPos > lt.smaWindow {
+		start := lt.smaPos % lt.smaWindow
+		ret = append(ret, lt.smaData[start:]...)
+		ret = append(ret, lt.smaData[:start]...)
+	} else {
+		ret = append(ret, lt.smaData[:n]...)
+	}
+	return ret
+}
+
 func (lt *LatencyTracker) Stop() {
 	close(lt.stop)
 }
+
+// decodeLatency decodes a LatencyPath response in either schema.
+func decodeLatency(r io.Reader) (map[string]LatencyStats, error) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	var v2 LatencyReport
+	if err := json.Unmarshal(raw, &v2); err == nil && v2.Version == SchemaV2 {
+		if v2.Latency == nil {
+			v2.Latency = map[string]LatencyStats{}
+		}
+		return v2.Latency, nil
+	}
+
+	v1 := map[string]int{}
+	if err := json.Unmarshal(raw, &v1); err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]LatencyStats, len(v1))
+	for host, latency := range v1 {
+		ret[host] = statsFromLatency(latency)
+	}
+
+	return ret, nil
+}
+
+// percentile uses the nearest-rank method on sorted samples.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	} else if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+func ms(d time.Duration) int {
+	return int(d / time.Millisecond)
+}
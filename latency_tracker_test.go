@@ -2,8 +2,11 @@ package regions
 
 import (
 	"context"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -11,8 +14,11 @@ import (
 )
 
 func TestLatencyTracker(t *testing.T) {
-	var app func(w http.ResponseWriter, r *http.Request)
-	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { app(w, r) }))
+	// trackers run by earlier cases can still be probing when app changes
+	var app atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.Load().(http.HandlerFunc)(w, r)
+	}))
 	t.Cleanup(srv.Close)
 
 	t.Run("base case", func(t *testing.T) {
@@ -21,7 +27,7 @@ func TestLatencyTracker(t *testing.T) {
 		assert.Equal(t, 0, lt.sma)
 		assert.Equal(t, 0, lt.nLocked())
 
-		app = func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("{}")) }
+		app.Store(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("{}")) }))
 
 		assert.NoError(t, lt.doRequest(context.Background()))
 		assert.True(t, lt.sma < time.Millisecond)
@@ -30,14 +36,14 @@ func TestLatencyTracker(t *testing.T) {
 		firstSMA := lt.sma
 		t.Logf("first sma=%v", firstSMA)
 
-		app = func(w http.ResponseWriter, r *http.Request) { time.Sleep(6 * time.Millisecond); w.Write([]byte("{}")) }
+		app.Store(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { time.Sleep(6 * time.Millisecond); w.Write([]byte("{}")) }))
 
 		assert.NoError(t, lt.doRequest(context.Background()))
 		assert.True(t, lt.sma < 4*time.Millisecond)
 		assert.True(t, lt.sma > 6*time.Millisecond/2)
 		assert.Equal(t, 2, lt.nLocked())
 
-		app = func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("{}")) }
+		app.Store(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("{}")) }))
 
 		for i := 3; i <= 10; i++ {
 			assert.NoError(t, lt.doRequest(context.Background()))
@@ -54,10 +60,89 @@ func TestLatencyTracker(t *testing.T) {
 		assert.True(t, lt.sma < expected*2, "sma=%v expected=%v", lt.sma, firstSMA)
 	})
 
+	t.Run("stats", func(t *testing.T) {
+		lt := NewLatencyTracker(srv.URL, 4, time.Second)
+
+		stats := lt.LatencyStats()
+		assert.Equal(t, math.MaxInt, stats.P50)
+		assert.Equal(t, 0, stats.Samples)
+
+		for _, d := range []time.Duration{10, 30, 20, 40, 50} {
+			lt.update(d*time.Millisecond, nil)
+		}
+
+		// window holds 30, 20, 40, 50
+		stats = lt.LatencyStats()
+		assert.True(t, time.Since(stats.Probes.LastSuccess) < time.Second)
+		stats.Probes.LastSuccess = time.Time{}
+		assert.Equal(t, LatencyStats{
+			SMA:     35,
+			P50:     30,
+			P90:     50,
+			P99:     50,
+			Min:     20,
+			Max:     50,
+			Jitter:  13,
+			Samples: 4,
+			Probes:  ProbeStats{Successes: 4},
+			Status:  PeerOK,
+		}, stats)
+		assert.Equal(t, 20, lt.Percentile(0))
+		assert.Equal(t, 40, lt.Percentile(75))
+	})
+
+	t.Run("probe failures", func(t *testing.T) {
+		// a request from an earlier case, like a retry on the hijacked
+		// connection, can still be running when the next case swaps handlers
+		var handler atomic.Value
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler.Load().(http.HandlerFunc)(w, r)
+		}))
+		t.Cleanup(srv.Close)
+
+		lt := NewLatencyTracker(srv.URL, 4, 5*time.Millisecond)
+		assert.Equal(t, PeerUnreachable, lt.Status())
+
+		handler.Store(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("{}")) }))
+		assert.NoError(t, lt.doRequest(context.Background()))
+		assert.Equal(t, PeerOK, lt.Status())
+
+		handler.Store(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		}))
+		assert.Error(t, lt.doRequest(context.Background()))
+
+		handler.Store(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { time.Sleep(10 * time.Millisecond) }))
+		assert.Error(t, lt.doRequest(context.Background()))
+
+		ps := lt.ProbeStats()
+		assert.Equal(t, 1, ps.Successes)
+		assert.Equal(t, 1, ps.Failures)
+		assert.Equal(t, 1, ps.Timeouts)
+		assert.Equal(t, 2.0/3.0, ps.LossRate)
+
+		// canceled probes aren't counted
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.Error(t, lt.doRequest(ctx))
+		assert.Equal(t, ps, lt.ProbeStats())
+
+		lt.m.Lock()
+		lt.lastSuccess = time.Now().Add(-staleIntervals*lt.interval - time.Millisecond)
+		lt.m.Unlock()
+		assert.Equal(t, PeerStale, lt.Status())
+
+		lt.m.Lock()
+		lt.lastSuccess = time.Now().Add(-unreachableIntervals*lt.interval - time.Millisecond)
+		lt.m.Unlock()
+		assert.Equal(t, PeerUnreachable, lt.Status())
+	})
+
 	t.Run("control", func(t *testing.T) {
 		lt := NewLatencyTracker(srv.URL, 10, 2*time.Millisecond)
 
-		app = func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("{}")) }
+		app.Store(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("{}")) }))
 		errc := lt.Run()
 
 		done := make(chan struct{})
@@ -69,8 +154,10 @@ func TestLatencyTracker(t *testing.T) {
 
 		time.Sleep(30 * time.Millisecond)
 
+		lt.m.RLock()
 		assert.True(t, lt.sma < time.Millisecond, "%v>time.Millisecond", lt.sma)
 		assert.Equal(t, 10, lt.nLocked())
+		lt.m.RUnlock()
 
 		lt.Stop()
 		select {
@@ -86,7 +173,7 @@ func TestLatencyTracker(t *testing.T) {
 	t.Run("slow server", func(t *testing.T) {
 		lt := NewLatencyTracker(srv.URL, 10, 2*time.Millisecond)
 
-		app = func(w http.ResponseWriter, r *http.Request) { time.Sleep(3 * time.Millisecond) }
+		app.Store(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { time.Sleep(3 * time.Millisecond) }))
 		errc := lt.Run()
 
 		for i := 0; i < 10; i++ {
@@ -111,10 +198,10 @@ func TestLatencyTracker(t *testing.T) {
 	t.Run("server error", func(t *testing.T) {
 		lt := NewLatencyTracker(srv.URL, 10, 2*time.Millisecond)
 
-		app = func(w http.ResponseWriter, r *http.Request) {
+		app.Store(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			conn, _, _ := w.(http.Hijacker).Hijack()
 			conn.Close()
-		}
+		}))
 
 		errc := lt.Run()
 
@@ -137,3 +224,21 @@ func TestLatencyTracker(t *testing.T) {
 		}
 	})
 }
+
+func TestDecodeLatency(t *testing.T) {
+	hl, err := decodeLatency(strings.NewReader(`{"iad":12,"ord":34}`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]LatencyStats{
+		"iad": statsFromLatency(12),
+		"ord": statsFromLatency(34),
+	}, hl)
+
+	hl, err = decodeLatency(strings.NewReader(`{"version":2,"latency":{"iad":{"sma":12,"p99":20,"samples":3}}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]LatencyStats{
+		"iad": {SMA: 12, P99: 20, Samples: 3},
+	}, hl)
+
+	_, err = decodeLatency(strings.NewReader(`[]`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,207 @@
+package regions
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseLocation parses a location given as one of:
+//
+//	geo:40.71,-74.01 - a latitude and longitude (RFC 5870 geo URI)
+//	US               - an ISO 3166-1 alpha-2 country code
+//	JFK              - an IATA airport code
+//
+// Countries are located at their largest city. Airports are the busiest ones
+// and those fly.io regions are named after.
+func ParseLocation(s string) (Location, error) {
+	if coords, isGeo := strings.CutPrefix(s, "geo:"); isGeo {
+		return parseGeo(coords)
+	}
+
+	code := strings.ToUpper(s)
+	switch len(code) {
+	case 2:
+		if loc, ok := countries[code]; ok {
+			return loc, nil
+		}
+		return Location{}, fmt.Errorf("unknown country code %q", s)
+	case 3:
+		if loc, ok := airports[code]; ok {
+			return loc, nil
+		}
+		if r, ok := Catalog[strings.ToLower(code)]; ok {
+			return r.Location, nil
+		}
+		return Location{}, fmt.Errorf("unknown airport code %q", s)
+	default:
+		return Location{}, fmt.Errorf("unknown location %q", s)
+	}
+}
+
+// parseGeo parses the lat,lon of a geo URI, ignoring any altitude or
+// parameters.
+func parseGeo(coords string) (Location, error) {
+	coords, _, _ = strings.Cut(coords, ";")
+	fields := strings.Split(coords, ",")
+	if len(fields) < 2 || len(fields) > 3 {
+		return Location{}, fmt.Errorf("bad geo location %q: want lat,lon", coords)
+	}
+
+	lat, err := strconv.ParseFloat(strings.TrimSpace(fields[0]), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return Location{}, fmt.Errorf("bad geo location %q: bad latitude", coords)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return Location{}, fmt.Errorf("bad geo location %q: bad longitude", coords)
+	}
+
+	return Location{lat, lon}, nil
+}
+
+// countries locates countries by ISO 3166-1 alpha-2 code at their largest
+// city.
+var countries = map[string]Location{
+	"AE": {25.20, 55.27},   // Dubai
+	"AR": {-34.60, -58.38}, // Buenos Aires
+	"AT": {48.21, 16.37},   // Vienna
+	"AU": {-33.87, 151.21}, // Sydney
+	"BD": {23.81, 90.41},   // Dhaka
+	"BE": {50.85, 4.35},    // Brussels
+	"BG": {42.70, 23.32},   // Sofia
+	"BO": {-17.81, -63.16}, // Santa Cruz
+	"BR": {-23.55, -46.63}, // São Paulo
+	"BY": {53.90, 27.57},   // Minsk
+	"CA": {43.65, -79.38},  // Toronto
+	"CH": {47.38, 8.54},    // Zurich
+	"CL": {-33.45, -70.67}, // Santiago
+	"CN": {31.23, 121.47},  // Shanghai
+	"CO": {4.71, -74.07},   // Bogotá
+	"CR": {9.93, -84.08},   // San José
+	"CZ": {50.08, 14.44},   // Prague
+	"DE": {52.52, 13.40},   // Berlin
+	"DK": {55.68, 12.57},   // Copenhagen
+	"DO": {18.49, -69.93},  // Santo Domingo
+	"DZ": {36.75, 3.06},    // Algiers
+	"EC": {-2.17, -79.92},  // Guayaquil
+	"EE": {59.44, 24.75},   // Tallinn
+	"EG": {30.04, 31.24},   // Cairo
+	"ES": {40.42, -3.70},   // Madrid
+	"ET": {9.03, 38.74},    // Addis Ababa
+	"FI": {60.17, 24.94},   // Helsinki
+	"FR": {48.86, 2.35},    // Paris
+	"GB": {51.51, -0.13},   // London
+	"GH": {5.60, -0.19},    // Accra
+	"GR": {37.98, 23.73},   // Athens
+	"GT": {14.63, -90.51},  // Guatemala City
+	"HK": {22.32, 114.17},  // Hong Kong
+	"HR": {45.81, 15.98},   // Zagreb
+	"HU": {47.50, 19.04},   // Budapest
+	"ID": {-6.21, 106.85},  // Jakarta
+	"IE": {53.35, -6.26},   // Dublin
+	"IL": {32.09, 34.78},   // Tel Aviv
+	"IN": {19.08, 72.88},   // Mumbai
+	"IQ": {33.32, 44.36},   // Baghdad
+	"IR": {35.69, 51.39},   // Tehran
+	"IS": {64.15, -21.94},  // Reykjavík
+	"IT": {41.90, 12.50},   // Rome
+	"JM": {18.02, -76.81},  // Kingston
+	"JO": {31.95, 35.93},   // Amman
+	"JP": {35.68, 139.69},  // Tokyo
+	"KE": {-1.29, 36.82},   // Nairobi
+	"KR": {37.57, 126.98},  // Seoul
+	"KW": {29.38, 47.99},   // Kuwait City
+	"KZ": {43.24, 76.89},   // Almaty
+	"LB": {33.89, 35.50},   // Beirut
+	"LK": {6.93, 79.86},    // Colombo
+	"LT": {54.69, 25.28},   // Vilnius
+	"LU": {49.61, 6.13},    // Luxembourg
+	"LV": {56.95, 24.11},   // Riga
+	"MA": {33.57, -7.59},   // Casablanca
+	"MX": {19.43, -99.13},  // Mexico City
+	"MY": {3.14, 101.69},   // Kuala Lumpur
+	"NG": {6.52, 3.38},     // Lagos
+	"NL": {52.37, 4.90},    // Amsterdam
+	"NO": {59.91, 10.75},   // Oslo
+	"NP": {27.72, 85.32},   // Kathmandu
+	"NZ": {-36.85, 174.76}, // Auckland
+	"PA": {8.98, -79.52},   // Panama City
+	"PE": {-12.05, -77.04}, // Lima
+	"PH": {14.60, 120.98},  // Manila
+	"PK": {24.86, 67.01},   // Karachi
+	"PL": {52.23, 21.01},   // Warsaw
+	"PR": {18.47, -66.11},  // San Juan
+	"PT": {38.72, -9.14},   // Lisbon
+	"PY": {-25.26, -57.58}, // Asunción
+	"QA": {25.29, 51.53},   // Doha
+	"RO": {44.43, 26.10},   // Bucharest
+	"RS": {44.79, 20.45},   // Belgrade
+	"RU": {55.76, 37.62},   // Moscow
+	"SA": {24.71, 46.68},   // Riyadh
+	"SE": {59.33, 18.07},   // Stockholm
+	"SG": {1.35, 103.82},   // Singapore
+	"SI": {46.06, 14.51},   // Ljubljana
+	"SK": {48.15, 17.11},   // Bratislava
+	"SV": {13.69, -89.22},  // San Salvador
+	"TH": {13.76, 100.50},  // Bangkok
+	"TN": {36.81, 10.18},   // Tunis
+	"TR": {41.01, 28.98},   // Istanbul
+	"TW": {25.03, 121.57},  // Taipei
+	"TZ": {-6.79, 39.21},   // Dar es Salaam
+	"UA": {50.45, 30.52},   // Kyiv
+	"UG": {0.35, 32.58},    // Kampala
+	"US": {40.71, -74.01},  // New York
+	"UY": {-34.90, -56.16}, // Montevideo
+	"UZ": {41.30, 69.24},   // Tashkent
+	"VE": {10.48, -66.90},  // Caracas
+	"VN": {10.82, 106.63},  // Ho Chi Minh City
+	"ZA": {-26.20, 28.05},  // Johannesburg
+}
+
+// airports locates the busiest airports by IATA code. Airports that fly.io
+// regions are named after are located by the Catalog.
+var airports = map[string]Location{
+	"AKL": {-37.01, 174.79}, // Auckland
+	"BCN": {41.30, 2.08},    // Barcelona
+	"BKK": {13.69, 100.75},  // Bangkok
+	"BLR": {13.20, 77.71},   // Bengaluru
+	"CAN": {23.39, 113.30},  // Guangzhou
+	"CGK": {-6.13, 106.66},  // Jakarta
+	"CLT": {35.21, -80.94},  // Charlotte
+	"CPH": {55.62, 12.65},   // Copenhagen
+	"DEL": {28.56, 77.10},   // Delhi
+	"DOH": {25.27, 51.61},   // Doha
+	"DTW": {42.21, -83.35},  // Detroit
+	"DUB": {53.42, -6.27},   // Dublin
+	"DXB": {25.25, 55.36},   // Dubai
+	"FCO": {41.80, 12.25},   // Rome
+	"HND": {35.55, 139.78},  // Tokyo
+	"HNL": {21.32, -157.92}, // Honolulu
+	"IAH": {29.98, -95.34},  // Houston
+	"ICN": {37.46, 126.44},  // Seoul
+	"IST": {41.26, 28.74},   // Istanbul
+	"JFK": {40.64, -73.78},  // New York
+	"KUL": {2.75, 101.71},   // Kuala Lumpur
+	"LAS": {36.08, -115.15}, // Las Vegas
+	"LIM": {-12.02, -77.11}, // Lima
+	"LIS": {38.77, -9.13},   // Lisbon
+	"MAN": {53.35, -2.28},   // Manchester
+	"MEL": {-37.67, 144.84}, // Melbourne
+	"MEX": {19.44, -99.07},  // Mexico City
+	"MNL": {14.51, 121.02},  // Manila
+	"MSP": {44.88, -93.22},  // Minneapolis
+	"MUC": {48.35, 11.79},   // Munich
+	"MXP": {45.63, 8.72},    // Milan
+	"NBO": {-1.32, 36.93},   // Nairobi
+	"OSL": {60.19, 11.10},   // Oslo
+	"PEK": {40.08, 116.58},  // Beijing
+	"PER": {-31.94, 115.97}, // Perth
+	"PVG": {31.14, 121.81},  // Shanghai
+	"SFO": {37.62, -122.38}, // San Francisco
+	"SVO": {55.97, 37.41},   // Moscow
+	"TPE": {25.08, 121.23},  // Taipei
+	"VIE": {48.11, 16.57},   // Vienna
+	"YVR": {49.19, -123.18}, // Vancouver
+	"ZRH": {47.46, 8.55},    // Zurich
+}
@@ -0,0 +1,133 @@
+package regions
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const metricsNamespace = "best_regions_"
+
+// MetricsWriter writes metrics in the Prometheus text exposition format.
+// Write errors are sticky and returned by Err.
+type MetricsWriter struct {
+	w   io.Writer
+	err error
+}
+
+func NewMetricsWriter(w io.Writer) *MetricsWriter {
+	return &MetricsWriter{w: w}
+}
+
+// Family writes the HELP and TYPE lines for a metric. It must be called once,
+// before the metric's samples are written.
+func (mw *MetricsWriter) Family(name, typ, help string) {
+	mw.printf("# HELP %s%s %s\n", metricsNamespace, name, strings.ReplaceAll(help, "\n", " "))
+	mw.printf("# TYPE %s%s %s\n", metricsNamespace, name, typ)
+}
+
+// Sample writes one sample. Labels are given as name/value pairs.
+func (mw *MetricsWriter) Sample(name string, value float64, labels ...string) {
+	mw.printf("%s%s%s %s\n", metricsNamespace, name, formatLabels(labels), formatValue(value))
+}
+
+// Histogram writes the bucket, sum and count samples of h.
+func (mw *MetricsWriter) Histogram(name string, h *Histogram, labels ...string) {
+	bounds, counts, sum, count := h.snapshot()
+
+	var cumulative uint64
+	for i, bound := range bounds {
+		cumulative += counts[i]
+		mw.Sample(name+"_bucket", float64(cumulative), append(labels[:len(labels):len(labels)], "le", formatValue(bound))...)
+	}
+	mw.Sample(name+"_bucket", float64(count), append(labels[:len(labels):len(labels)], "le", "+Inf")...)
+	mw.Sample(name+"_sum", sum, labels...)
+	mw.Sample(name+"_count", float64(count), labels...)
+}
+
+func (mw *MetricsWriter) Err() error {
+	return mw.err
+}
+
+func (mw *MetricsWriter) printf(format string, args ...any) {
+	if mw.err != nil {
+		return
+	}
+	_, mw.err = fmt.Fprintf(mw.w, format, args...)
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	if len(labels)%2 != 0 {
+		panic("odd number of label name/values")
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i])
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(labels[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// Histogram counts observations into buckets with the given upper bounds.
+type Histogram struct {
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+	m      sync.Mutex
+}
+
+func NewHistogram(bounds ...float64) *Histogram {
+	bounds = append([]float64(nil), bounds...)
+	sort.Float64s(bounds)
+	return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+// probeBuckets are upper bounds in seconds for probe round trip histograms.
+var probeBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+
+func (h *Histogram) Observe(v float64) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if i := sort.SearchFloat64s(h.bounds, v); i < len(h.bounds) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *Histogram) snapshot() ([]float64, []uint64, float64, uint64) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return h.bounds, append([]uint64(nil), h.counts...), h.sum, h.count
+}
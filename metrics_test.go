@@ -0,0 +1,40 @@
+package regions
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestMetricsWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	mw := NewMetricsWriter(buf)
+
+	mw.Family("latency_milliseconds", "gauge", "Latency.")
+	mw.Sample("latency_milliseconds", 12.5, "src", "iad", "dst", `f"r\a`)
+	mw.Sample("latency_milliseconds", math.Inf(1))
+
+	h := NewHistogram(1, 0.1)
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(2)
+
+	mw.Family("probe_duration_seconds", "histogram", "Probes.")
+	mw.Histogram("probe_duration_seconds", h, "dst", "fra")
+
+	assert.NoError(t, mw.Err())
+	assert.Equal(t, `# HELP best_regions_latency_milliseconds Latency.
+# TYPE best_regions_latency_milliseconds gauge
+best_regions_latency_milliseconds{src="iad",dst="f\"r\\a"} 12.5
+best_regions_latency_milliseconds +Inf
+# HELP best_regions_probe_duration_seconds Probes.
+# TYPE best_regions_probe_duration_seconds histogram
+best_regions_probe_duration_seconds_bucket{dst="fra",le="0.1"} 1
+best_regions_probe_duration_seconds_bucket{dst="fra",le="1"} 2
+best_regions_probe_duration_seconds_bucket{dst="fra",le="+Inf"} 3
+best_regions_probe_duration_seconds_sum{dst="fra"} 2.55
+best_regions_probe_duration_seconds_count{dst="fra"} 3
+`, buf.String())
+}
@@ -0,0 +1,221 @@
+package regions
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// DefaultEchoPort is the UDP port that Server answers UDPProber probes on if
+// EnvEchoPort isn't set. It's unprivileged, so the server needs no special
+// permissions to bind it.
+const DefaultEchoPort = "7777"
+
+type ProbeMethod string
+
+const (
+	ProbeHTTP ProbeMethod = "http"
+	ProbeTCP  ProbeMethod = "tcp"
+	ProbeUDP  ProbeMethod = "udp"
+)
+
+// Prober measures a single round trip to a peer.
+type Prober interface {
+	Probe(ctx context.Context) (time.Duration, error)
+}
+
+// latencyProber is implemented by probers that can fetch the peer's latency
+// report in the same round trip they measure.
+type latencyProber interface {
+	ProbeLatency(ctx context.Context) (time.Duration, map[string]LatencyStats, error)
+}
+
+// NewProber returns a prober for the given method against a peer's host. An
+// empty method is the same as ProbeHTTP.
+func NewProber(method ProbeMethod, host string) (Prober, error) {
+	switch method {
+	case ProbeHTTP, "":
+		return &HTTPProber{URL: "http://" + host + versionedPath(LatencyPath, SchemaV2)}, nil
+	case ProbeTCP:
+		return &TCPProber{Addr: net.JoinHostPort(host, "80")}, nil
+	case ProbeUDP:
+		return &UDPProber{Addr: net.JoinHostPort(host, echoPort())}, nil
+	default:
+		return nil, fmt.Errorf("unknown probe method %q", method)
+	}
+}
+
+// echoPort is the UDP port UDPProbers probe and Server answers on. Every region
+// must use the same one.
+func echoPort() string {
+	if EnvEchoPort != "" {
+		return EnvEchoPort
+	}
+	return DefaultEchoPort
+}
+
+// HTTPProber measures the time between finishing writing a request for the
+// peer's latency report and reading the first byte of the response. This
+// includes the peer's handler time.
+type HTTPProber struct {
+	URL    string
+	Client *http.Client
+}
+
+var _ Prober = (*HTTPProber)(nil)
+
+func (p *HTTPProber) Probe(ctx context.Context) (time.Duration, error) {
+	rtt, _, err := p.ProbeLatency(ctx)
+	return rtt, err
+}
+
+func (p *HTTPProber) ProbeLatency(ctx context.Context) (time.Duration, map[string]LatencyStats, error) {
+	// try to measure single round trip by looking at interval between
+	// finishing sending request and starting to read response.
+	var start, end time.Time
+	tctx := httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteRequest:         func(wri httptrace.WroteRequestInfo) { start = time.Now() },
+		GotFirstResponseByte: func() { end = time.Now() },
+	})
+
+	req, err := http.NewRequestWithContext(tctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	hl, err := decodeLatency(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := io.Copy(buf, resp.Body); err != nil {
+		return 0, nil, err
+	}
+
+	switch {
+	case start.IsZero():
+		return 0, nil, errors.New("zero start")
+	case end.IsZero():
+		return 0, nil, errors.New("zero end")
+	}
+
+	return end.Sub(start), hl, nil
+}
+
+// TCPProber measures the time to establish a new TCP connection to the peer,
+// which is one round trip for the handshake.
+type TCPProber struct {
+	Addr string
+}
+
+var _ Prober = (*TCPProber)(nil)
+
+func (p *TCPProber) Probe(ctx context.Context) (time.Duration, error) {
+	var d net.Dialer
+
+	start := time.Now()
+	conn, err := d.DialContext(ctx, "tcp", p.Addr)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+
+	return rtt, conn.Close()
+}
+
+// UDPProber measures the time for a datagram to be echoed back by the peer's
+// UDP echo responder.
+type UDPProber struct {
+	Addr string
+}
+
+var _ Prober = (*UDPProber)(nil)
+
+func (p *UDPProber) Probe(ctx context.Context) (time.Duration, error) {
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "udp", p.Addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return 0, err
+		}
+	}
+
+	// unblock reads if context is canceled without a deadline
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	nonce := make([]byte, 8)
+	binary.BigEndian.PutUint64(nonce, rand.Uint64())
+
+	start := time.Now()
+	if _, err := conn.Write(nonce); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 64)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return 0, ctxErr
+			}
+			return 0, err
+		}
+
+		// ignore stale replies to earlier probes
+		if bytes.Equal(buf[:n], nonce) {
+			return time.Since(start), nil
+		}
+	}
+}
+
+// serveEcho answers UDPProber probes until conn is closed. Errors replying to
+// individual probes are sent to errc.
+func serveEcho(conn net.PacketConn, errc chan error) error {
+	buf := make([]byte, 64)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if _, err := conn.WriteTo(buf[:n], addr); err != nil {
+			sendErr(errc, fmt.Errorf("echo: %w", err))
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package regions
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestProbers(t *testing.T) {
+	var reports int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&reports, 1)
+		w.Write([]byte(`{"version":2,"latency":{"iad":{"sma":12}}}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Run("http", func(t *testing.T) {
+		p := &HTTPProber{URL: srv.URL + LatencyPath}
+
+		rtt, hl, err := p.ProbeLatency(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, rtt > 0 && rtt < time.Second, "rtt=%v", rtt)
+		assert.Equal(t, map[string]LatencyStats{"iad": {SMA: 12}}, hl)
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		p := &TCPProber{Addr: srv.Listener.Addr().String()}
+
+		rtt, err := p.Probe(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, rtt > 0 && rtt < time.Second, "rtt=%v", rtt)
+	})
+
+	t.Run("udp", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		assert.NoError(t, err)
+
+		errc := make(chan error)
+		done := make(chan error)
+		go func() { done <- serveEcho(conn, errc) }()
+
+		p := &UDPProber{Addr: conn.LocalAddr().String()}
+
+		rtt, err := p.Probe(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, rtt > 0 && rtt < time.Second, "rtt=%v", rtt)
+
+		conn.Close()
+		assert.NoError(t, <-done)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		defer cancel()
+		_, err = p.Probe(ctx)
+		assert.Error(t, err)
+	})
+
+	t.Run("tracker", func(t *testing.T) {
+		lt := NewLatencyTrackerWithProber(srv.URL, &TCPProber{Addr: srv.Listener.Addr().String()}, 10, time.Second)
+		atomic.StoreInt32(&reports, 0)
+
+		assert.NoError(t, lt.doRequest(context.Background()))
+		assert.Equal(t, 1, lt.nLocked())
+		assert.Equal(t, map[string]int{"iad": 12}, lt.Latencies())
+
+		// the report isn't fetched again until it's due
+		assert.NoError(t, lt.doRequest(context.Background()))
+		assert.Equal(t, 2, lt.nLocked())
+		assert.Equal(t, int32(1), atomic.LoadInt32(&reports))
+
+		lt.m.Lock()
+		lt.lastReport = time.Now().Add(-reportIntervals * lt.interval)
+		lt.m.Unlock()
+		assert.NoError(t, lt.doRequest(context.Background()))
+		assert.Equal(t, int32(2), atomic.LoadInt32(&reports))
+	})
+}
+
+func TestNewProber(t *testing.T) {
+	p, err := NewProber("", "iad.best-regions.internal")
+	assert.NoError(t, err)
+	assert.Equal(t, Prober(&HTTPProber{URL: "http://iad.best-regions.internal/latency.json?v=2"}), p)
+
+	p, err = NewProber(ProbeTCP, "iad.best-regions.internal")
+	assert.NoError(t, err)
+	assert.Equal(t, Prober(&TCPProber{Addr: "iad.best-regions.internal:80"}), p)
+
+	p, err = NewProber(ProbeUDP, "iad.best-regions.internal")
+	assert.NoError(t, err)
+	assert.Equal(t, Prober(&UDPProber{Addr: "iad.best-regions.internal:7777"}), p)
+
+	_, err = NewProber("icmp", "iad.best-regions.internal")
+	assert.Error(t, err)
+}
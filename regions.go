@@ -4,21 +4,60 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 )
 
 const (
 	LatencyPath   = "/latency.json"
 	LatenciesPath = "/latencies.json"
 	StatsPath     = "/stats.json"
+	HistoryPath   = "/history.json"
+	MetricsPath   = "/metrics"
 )
 
+// Schema versions for LatencyPath and LatenciesPath, selected with the "v"
+// query parameter. Requests without the parameter get v1, which maps regions
+// to their SMA latency in milliseconds.
+const (
+	SchemaV1 = 1
+	SchemaV2 = 2
+)
+
+// LatencyReport is the v2 schema for LatencyPath.
+type LatencyReport struct {
+	Version int                     `json:"version"`
+	Latency map[string]LatencyStats `json:"latency"`
+}
+
+// LatenciesReport is the v2 schema for LatenciesPath.
+type LatenciesReport struct {
+	Version   int                                `json:"version"`
+	Latencies map[string]map[string]LatencyStats `json:"latencies"`
+}
+
 var (
 	EnvFlyApp    = os.Getenv("FLY_APP_NAME")
 	EnvFlyRegion = os.Getenv("FLY_REGION")
+
+	// EnvSnapshotPath is where latency history is persisted across restarts.
+	// Persistence is disabled if it's empty.
+	EnvSnapshotPath = os.Getenv("SNAPSHOT_PATH")
+
+	// EnvHistoryPath is where latency history served from HistoryPath is
+	// persisted across restarts. History is only kept in memory if it's empty.
+	EnvHistoryPath = os.Getenv("HISTORY_PATH")
+
+	// EnvEchoPort is the UDP port probed by the udp probe method. It defaults
+	// to DefaultEchoPort.
+	EnvEchoPort = os.Getenv("ECHO_PORT")
 )
 
 func DeployedRegions(ctx context.Context) ([]string, error) {
@@ -36,11 +75,13 @@ func DeployedRegions(ctx context.Context) ([]string, error) {
 }
 
 type RegionLatencyTracker struct {
-	trackers  map[string]*LatencyTracker
-	smaWindow int
-	interval  time.Duration
-	stop      chan struct{}
-	m         sync.Mutex
+	trackers    map[string]*LatencyTracker
+	smaWindow   int
+	interval    time.Duration
+	probeMethod ProbeMethod
+	restored    map[string]peerSnapshot
+	stop        chan struct{}
+	m           sync.Mutex
 }
 
 func NewRegionLatencyTracker(smaWindow int, interval time.Duration) *RegionLatencyTracker {
@@ -52,6 +93,21 @@ func NewRegionLatencyTracker(smaWindow int, interval time.Duration) *RegionLaten
 	}
 }
 
+// SetProbeMethod sets how round trips to newly discovered regions are
+// measured.
+func (rlt *RegionLatencyTracker) SetProbeMethod(method ProbeMethod) error {
+	if _, err := NewProber(method, ""); err != nil {
+		return err
+	}
+
+	rlt.m.Lock()
+	defer rlt.m.Unlock()
+
+	rlt.probeMethod = method
+
+	return nil
+}
+
 func (rlt *RegionLatencyTracker) Run() <-chan error {
 	errc := make(chan error)
 
@@ -111,8 +167,17 @@ func (rlt *RegionLatencyTracker) updateRegions(ctx context.Context, errc chan er
 
 		// new region?
 		if _, exists := rlt.trackers[region]; !exists {
-			url := "http://" + name(region, EnvFlyApp, "internal")
-			tracker := NewLatencyTracker(url, rlt.smaWindow, rlt.interval)
+			host := name(region, EnvFlyApp, "internal")
+			prober, err := NewProber(rlt.probeMethod, host)
+			if err != nil {
+				sendErr(errc, fmt.Errorf("region tracker: %w", err))
+				continue
+			}
+			tracker := NewLatencyTrackerWithProber("http://"+host, prober, rlt.smaWindow, rlt.interval)
+			if ps, ok := rlt.restored[region]; ok {
+				tracker.restore(ps)
+				delete(rlt.restored, region)
+			}
 			rlt.trackers[region] = tracker
 
 			go func() {
@@ -133,6 +198,10 @@ func (rlt *RegionLatencyTracker) updateRegions(ctx context.Context, errc chan er
 	}
 }
 
+// Latencies returns every region's view of latency to the other regions.
+// Views reported by stale or unreachable peers are omitted rather than served
+// out of date. The local view leaves out stale peers too, and reports
+// unreachable peers with math.MaxInt latency.
 func (rlt *RegionLatencyTracker) Latencies() map[string]map[string]int {
 	rlt.m.Lock()
 	defer rlt.m.Unlock()
@@ -140,6 +209,9 @@ func (rlt *RegionLatencyTracker) Latencies() map[string]map[string]int {
 	ret := make(map[string]map[string]int, len(rlt.trackers)+1)
 
 	for region, tracker := range rlt.trackers {
+		if tracker.Status() != PeerOK {
+			continue
+		}
 		ret[region] = tracker.Latencies()
 	}
 
@@ -158,12 +230,93 @@ func (rlt *RegionLatencyTracker) latencyLocked() map[string]int {
 	ret := make(map[string]int, len(rlt.trackers))
 
 	for region, tracker := range rlt.trackers {
-		ret[region] = tracker.Latency()
+		switch tracker.Status() {
+		case PeerUnreachable:
+			ret[region] = math.MaxInt
+		case PeerStale:
+			// its latency is out of date, so it's missing until probed again
+		default:
+			ret[region] = tracker.Latency()
+		}
+	}
+
+	return ret
+}
+
+// LatenciesStats is like Latencies, but includes percentiles and probe stats.
+// Each peer's status is reported in the local region's stats.
+func (rlt *RegionLatencyTracker) LatenciesStats() map[string]map[string]LatencyStats {
+	rlt.m.Lock()
+	defer rlt.m.Unlock()
+
+	ret := make(map[string]map[string]LatencyStats, len(rlt.trackers)+1)
+
+	for region, tracker := range rlt.trackers {
+		if tracker.Status() != PeerOK {
+			continue
+		}
+		ret[region] = tracker.LatenciesStats()
 	}
 
+	ret[EnvFlyRegion] = rlt.latencyStatsLocked()
+
 	return ret
 }
 
+func (rlt *RegionLatencyTracker) LatencyStats() map[string]LatencyStats {
+	rlt.m.Lock()
+	defer rlt.m.Unlock()
+	return rlt.latencyStatsLocked()
+}
+
+func (rlt *RegionLatencyTracker) latencyStatsLocked() map[string]LatencyStats {
+	ret := make(map[string]LatencyStats, len(rlt.trackers))
+
+	for region, tracker := range rlt.trackers {
+		ret[region] = tracker.LatencyStats()
+	}
+
+	return ret
+}
+
+func (rlt *RegionLatencyTracker) writeMetrics(mw *MetricsWriter) {
+	latencies := rlt.Latencies()
+
+	rlt.m.Lock()
+	defer rlt.m.Unlock()
+
+	regions := maps.Keys(rlt.trackers)
+	slices.Sort(regions)
+
+	mw.Family("tracked_regions", "gauge", "Number of other regions being probed.")
+	mw.Sample("tracked_regions", float64(len(rlt.trackers)))
+
+	mw.Family("latency_milliseconds", "gauge", "Moving average latency from src to dst region.")
+	srcs := maps.Keys(latencies)
+	slices.Sort(srcs)
+	for _, src := range srcs {
+		dsts := maps.Keys(latencies[src])
+		slices.Sort(dsts)
+		for _, dst := range dsts {
+			if l := latencies[src][dst]; l != math.MaxInt {
+				mw.Sample("latency_milliseconds", float64(l), "src", src, "dst", dst)
+			}
+		}
+	}
+
+	mw.Family("probe_duration_seconds", "histogram", "Round trip time of successful probes from this region.")
+	for _, region := range regions {
+		mw.Histogram("probe_duration_seconds", rlt.trackers[region].probeHist, "src", EnvFlyRegion, "dst", region)
+	}
+
+	mw.Family("probe_errors_total", "counter", "Failed probes from this region.")
+	for _, region := range regions {
+		failures, timeouts := rlt.trackers[region].probeErrors()
+		mw.Sample("probe_errors_total", float64(failures), "src", EnvFlyRegion, "dst", region, "kind", "failure")
+		mw.Sample("probe_errors_total", float64(timeouts), "src", EnvFlyRegion, "dst", region, "kind", "timeout")
+	}
+}
+
 func (rlt *RegionLatencyTracker) Stop() {
 	rlt.m.Lock()
 	defer rlt.m.Unlock()
@@ -183,6 +336,13 @@ func sendErr(errc chan error, err error) {
 	}
 }
 
+func versionedPath(path string, version int) string {
+	if version == SchemaV1 {
+		return path
+	}
+	return path + "?v=" + strconv.Itoa(version)
+}
+
 func name(parts ...string) string {
 	return strings.Join(parts, ".")
 }
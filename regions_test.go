@@ -2,7 +2,9 @@ package regions
 
 import (
 	"context"
+	"math"
 	"testing"
+	"time"
 
 	"github.com/alecthomas/assert/v2"
 )
@@ -27,3 +29,28 @@ func TestDeployedRegions(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, deployedRegions, regions)
 }
+
+func TestLatenciesPeerStatus(t *testing.T) {
+	rlt := NewRegionLatencyTracker(10, time.Second)
+
+	ok := NewLatencyTracker("", 10, time.Second)
+	ok.update(20*time.Millisecond, map[string]LatencyStats{"den": statsFromLatency(20)})
+
+	stale := NewLatencyTracker("", 10, time.Second)
+	stale.update(30*time.Millisecond, map[string]LatencyStats{"den": statsFromLatency(30)})
+	stale.lastSuccess = time.Now().Add(-5 * time.Second)
+
+	unreachable := NewLatencyTracker("", 10, time.Second)
+
+	rlt.trackers = map[string]*LatencyTracker{"ord": ok, "iad": stale, "lax": unreachable}
+
+	assert.Equal(t, map[string]map[string]int{
+		"ord": {"den": 20},
+		"den": {"ord": 20, "lax": math.MaxInt},
+	}, rlt.Latencies())
+
+	stats := rlt.LatencyStats()
+	assert.Equal(t, PeerOK, stats["ord"].Status)
+	assert.Equal(t, PeerStale, stats["iad"].Status)
+	assert.Equal(t, PeerUnreachable, stats["lax"].Status)
+}
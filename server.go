@@ -3,12 +3,19 @@ package regions
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 )
 
 const (
@@ -17,14 +24,19 @@ const (
 )
 
 type Server struct {
-	srv       *http.Server
-	rlt       *RegionLatencyTracker
-	data      map[string][]byte
-	reqCounts map[string]*uint64
-	stopOnce  sync.Once
-	stop      chan struct{}
-	log       *log.Logger
-	m         sync.RWMutex
+	srv          *http.Server
+	rlt          *RegionLatencyTracker
+	snapshotPath string
+	history      *History
+	historyPath  string
+	loadErr      error
+	data         map[string][]byte
+	reqCounts    map[string]*uint64
+	collectors   []func(*MetricsWriter)
+	stopOnce     sync.Once
+	stop         chan struct{}
+	log          *log.Logger
+	m            sync.RWMutex
 }
 
 func NewServer(smaWindow int, interval time.Duration, mux *http.ServeMux) *Server {
@@ -39,20 +51,40 @@ func NewServer(smaWindow int, interval time.Duration, mux *http.ServeMux) *Serve
 	}
 
 	s := &Server{
-		rlt:  NewRegionLatencyTracker(smaWindow, interval),
-		data: map[string][]byte{},
+		rlt:          NewRegionLatencyTracker(smaWindow, interval),
+		snapshotPath: EnvSnapshotPath,
+		history:      NewHistory(),
+		historyPath:  EnvHistoryPath,
+		data:         map[string][]byte{},
 		reqCounts: map[string]*uint64{
 			LatenciesPath: new(uint64),
 			LatencyPath:   new(uint64),
 			StatsPath:     new(uint64),
+			HistoryPath:   new(uint64),
+			MetricsPath:   new(uint64),
 		},
 		stop: make(chan struct{}),
 		log:  log.New(io.Discard, "", 0),
 	}
 
+	// warm start from previous run. errors are logged once Run is called,
+	// since log output hasn't been configured yet.
+	if s.snapshotPath != "" {
+		if err := s.rlt.Load(s.snapshotPath); err != nil {
+			s.loadErr = errors.Join(s.loadErr, fmt.Errorf("snapshot: %w", err))
+		}
+	}
+	if s.historyPath != "" {
+		if err := s.history.Load(s.historyPath); err != nil {
+			s.loadErr = errors.Join(s.loadErr, fmt.Errorf("history: %w", err))
+		}
+	}
+
 	mux.Handle(LatenciesPath, s.serveData(LatenciesPath))
 	mux.Handle(LatencyPath, s.serveData(LatencyPath))
 	mux.Handle(StatsPath, s.serveData(StatsPath))
+	mux.Handle(HistoryPath, s.serveHistory())
+	mux.Handle(MetricsPath, s.serveMetrics())
 
 	s.srv = &http.Server{Addr: ":80", Handler: mux}
 
@@ -61,8 +93,17 @@ func NewServer(smaWindow int, interval time.Duration, mux *http.ServeMux) *Serve
 
 func (s *Server) serveData(path string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := SchemaV1
+		if v := r.URL.Query().Get("v"); v != "" {
+			var err error
+			if version, err = strconv.Atoi(v); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+
 		s.m.RLock()
-		data, ok := s.data[path]
+		data, ok := s.data[versionedPath(path, version)]
 		s.m.RUnlock()
 
 		if !ok {
@@ -77,6 +118,74 @@ func (s *Server) serveData(path string) http.Handler {
 	})
 }
 
+func (s *Server) serveHistory() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, err := ParseHistoryQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		series, err := s.history.Query(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.incrReqCount(r.URL.Path)
+
+		w.Header().Set("Content-Type", "application/json")
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(series); err != nil {
+			s.log.Printf("json: %s", err)
+		}
+	})
+}
+
+func (s *Server) serveMetrics() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.incrReqCount(r.URL.Path)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		mw := NewMetricsWriter(w)
+		s.writeMetrics(mw)
+		if err := mw.Err(); err != nil {
+			s.log.Printf("metrics: %s", err)
+		}
+	})
+}
+
+func (s *Server) writeMetrics(mw *MetricsWriter) {
+	s.rlt.writeMetrics(mw)
+
+	paths := maps.Keys(s.reqCounts)
+	slices.Sort(paths)
+
+	mw.Family("requests_total", "counter", "Requests served by path.")
+	for _, path := range paths {
+		mw.Sample("requests_total", float64(atomic.LoadUint64(s.reqCounts[path])), "path", path)
+	}
+
+	s.m.RLock()
+	collectors := s.collectors
+	s.m.RUnlock()
+
+	for _, collect := range collectors {
+		collect(mw)
+	}
+}
+
+// AddMetrics registers a function that writes additional metrics to
+// MetricsPath.
+func (s *Server) AddMetrics(collect func(*MetricsWriter)) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.collectors = append(s.collectors, collect)
+}
+
 func (s *Server) LogOutput(w io.Writer) {
 	s.log.SetOutput(w)
 }
@@ -85,9 +194,26 @@ func (s *Server) Latencies() map[string]map[string]int {
 	return s.rlt.Latencies()
 }
 
+func (s *Server) LatenciesStats() map[string]map[string]LatencyStats {
+	return s.rlt.LatenciesStats()
+}
+
+// SetProbeMethod sets how round trips to other regions are measured. It should
+// be called before Run.
+func (s *Server) SetProbeMethod(method ProbeMethod) error {
+	return s.rlt.SetProbeMethod(method)
+}
+
 func (s *Server) Run() error {
+	if s.loadErr != nil {
+		s.log.Printf("warm start: %s", s.loadErr)
+	}
+
 	go s.runRLT()
+	go s.runEcho()
 	go s.updateData()
+	go s.runHistory()
+	go s.runSnapshots()
 	if err := s.srv.ListenAndServe(); err != http.ErrServerClosed {
 		return err
 	}
@@ -96,6 +222,10 @@ func (s *Server) Run() error {
 
 func (s *Server) Shutdown(ctx context.Context) error {
 	s.log.Println("graceful shutdown")
+
+	// trackers are discarded once stopped, so snapshot first
+	s.saveSnapshot()
+
 	s.stopOnce.Do(func() { close(s.stop) })
 	if err := s.srv.Shutdown(ctx); err != nil {
 		s.log.Println(err)
@@ -137,6 +267,24 @@ func (s *Server) updateData() {
 			s.m.Unlock()
 		}
 
+		latenciesStats := s.rlt.LatenciesStats()
+
+		if j, err := json.MarshalIndent(LatenciesReport{Version: SchemaV2, Latencies: latenciesStats}, "", "  "); err != nil {
+			s.log.Printf("json: %s", err)
+		} else {
+			s.m.Lock()
+			s.data[versionedPath(LatenciesPath, SchemaV2)] = j
+			s.m.Unlock()
+		}
+
+		if j, err := json.MarshalIndent(LatencyReport{Version: SchemaV2, Latency: latenciesStats[EnvFlyRegion]}, "", "  "); err != nil {
+			s.log.Printf("json: %s", err)
+		} else {
+			s.m.Lock()
+			s.data[versionedPath(LatencyPath, SchemaV2)] = j
+			s.m.Unlock()
+		}
+
 		stats := map[string]uint64{}
 		for path, ptr := range s.reqCounts {
 			stats[path] = atomic.LoadUint64(ptr)
@@ -174,3 +322,75 @@ func (s *Server) runRLT() {
 func (s *Server) incrReqCount(path string) {
 	atomic.AddUint64(s.reqCounts[path], 1)
 }
+
+// runEcho answers UDP probes from other regions' UDPProbers.
+func (s *Server) runEcho() {
+	conn, err := net.ListenPacket("udp", ":"+echoPort())
+	if err != nil {
+		s.log.Printf("echo: %s", err)
+		return
+	}
+
+	go func() {
+		<-s.stop
+		conn.Close()
+	}()
+
+	errc := make(chan error)
+	defer close(errc)
+
+	go func() {
+		for err := range errc {
+			s.log.Println(err)
+		}
+	}()
+
+	if err := serveEcho(conn, errc); err != nil {
+		s.log.Printf("echo: %s", err)
+	}
+}
+
+func (s *Server) runHistory() {
+	tkr := time.NewTicker(s.rlt.interval)
+	defer tkr.Stop()
+
+	for {
+		select {
+		case t := <-tkr.C:
+			s.history.Record(s.rlt.Latencies(), t)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Server) runSnapshots() {
+	if s.snapshotPath == "" && s.historyPath == "" {
+		return
+	}
+
+	tkr := time.NewTicker(snapshotInterval)
+	defer tkr.Stop()
+
+	for {
+		select {
+		case <-tkr.C:
+			s.saveSnapshot()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Server) saveSnapshot() {
+	if s.snapshotPath != "" {
+		if err := s.rlt.Save(s.snapshotPath); err != nil {
+			s.log.Printf("snapshot: %s", err)
+		}
+	}
+	if s.historyPath != "" {
+		if err := s.history.Save(s.historyPath); err != nil {
+			s.log.Printf("history: %s", err)
+		}
+	}
+}
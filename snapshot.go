@@ -0,0 +1,164 @@
+package regions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	snapshotVersion = 1
+
+	// snapshots older than this aren't worth warm-starting from.
+	maxSnapshotAge = 24 * time.Hour
+
+	snapshotInterval = time.Minute
+)
+
+var errSnapshotStale = errors.New("snapshot too old")
+
+type snapshot struct {
+	Version int                     `json:"version"`
+	Region  string                  `json:"region"`
+	Time    time.Time               `json:"time"`
+	Peers   map[string]peerSnapshot `json:"peers"`
+}
+
+type peerSnapshot struct {
+	Samples       []time.Duration         `json:"samples"`
+	Probes        []probeResult           `json:"probes"`
+	LastSuccess   time.Time               `json:"last_success"`
+	HostLatencies map[string]LatencyStats `json:"host_latencies"`
+}
+
+// Save writes the samples and host latencies of every tracked region to path.
+// The file is replaced atomically.
+func (rlt *RegionLatencyTracker) Save(path string) error {
+	rlt.m.Lock()
+	snap := snapshot{
+		Version: snapshotVersion,
+		Region:  EnvFlyRegion,
+		Time:    time.Now(),
+		Peers:   make(map[string]peerSnapshot, len(rlt.trackers)),
+	}
+	for region, tracker := range rlt.trackers {
+		snap.Peers[region] = tracker.snapshot()
+	}
+	rlt.m.Unlock()
+
+	j, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, j)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over path so
+// readers never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load reads a snapshot written by Save. Regions in the snapshot are
+// warm-started with its data once they're discovered. Their last successful
+// probes are moved forward by the time since the snapshot, so peers start with
+// the status they had when it was saved rather than every one being
+// unreachable. A missing file isn't an error. Corrupt, old or foreign
+// snapshots are ignored and an error is returned.
+func (rlt *RegionLatencyTracker) Load(path string) error {
+	j, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(j, &snap); err != nil {
+		return fmt.Errorf("corrupt snapshot: %w", err)
+	}
+
+	switch {
+	case snap.Version != snapshotVersion:
+		return fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	case snap.Region != EnvFlyRegion:
+		return fmt.Errorf("snapshot is from region %q", snap.Region)
+	case time.Since(snap.Time) > maxSnapshotAge:
+		return errSnapshotStale
+	}
+
+	if downtime := time.Since(snap.Time); downtime > 0 {
+		for region, ps := range snap.Peers {
+			if !ps.LastSuccess.IsZero() {
+				ps.LastSuccess = ps.LastSuccess.Add(downtime)
+				snap.Peers[region] = ps
+			}
+		}
+	}
+
+	rlt.m.Lock()
+	defer rlt.m.Unlock()
+
+	rlt.restored = snap.Peers
+
+	return nil
+}
+
+func (lt *LatencyTracker) snapshot() peerSnapshot {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+
+	return peerSnapshot{
+		Samples:       lt.samplesLocked(),
+		Probes:        lt.probesLocked(),
+		LastSuccess:   lt.lastSuccess,
+		HostLatencies: lt.hostLatencies,
+	}
+}
+
+// restore replaces the tracker's window with snapshotted data, keeping the
+// newest samples if the snapshot was taken with a larger window.
+func (lt *LatencyTracker) restore(ps peerSnapshot) {
+	lt.m.Lock()
+	defer lt.m.Unlock()
+
+	samples := ps.Samples
+	if len(samples) > lt.smaWindow {
+		samples = samples[len(samples)-lt.smaWindow:]
+	}
+	probes := ps.Probes
+	if len(probes) > lt.smaWindow {
+		probes = probes[len(probes)-lt.smaWindow:]
+	}
+
+	lt.smaPos = copy(lt.smaData, samples)
+	lt.probePos = copy(lt.probeData, probes)
+	lt.lastSuccess = ps.LastSuccess
+	lt.hostLatencies = ps.HostLatencies
+
+	var sum time.Duration
+	for _, s := range samples {
+		sum += s
+	}
+	if len(samples) > 0 {
+		lt.sma = sum / time.Duration(len(samples))
+	}
+}
@@ -0,0 +1,114 @@
+package regions
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"golang.org/x/exp/maps"
+)
+
+func TestSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+
+	t.Run("missing", func(t *testing.T) {
+		rlt := NewRegionLatencyTracker(4, time.Second)
+		assert.NoError(t, rlt.Load(path))
+		assert.Equal(t, 0, len(rlt.restored))
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		lt := NewLatencyTracker("", 4, time.Second)
+		for _, d := range []time.Duration{10, 20, 30, 40, 50} {
+			lt.update(d*time.Millisecond, map[string]LatencyStats{"den": statsFromLatency(int(d))})
+		}
+		lt.fail(os.ErrClosed)
+
+		rlt := NewRegionLatencyTracker(4, time.Second)
+		rlt.trackers["ord"] = lt
+		assert.NoError(t, rlt.Save(path))
+
+		j, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Contains(t, string(j), `"probes":["success","success","success","failure"]`)
+
+		rlt = NewRegionLatencyTracker(4, time.Second)
+		assert.NoError(t, rlt.Load(path))
+		assert.Equal(t, []string{"ord"}, maps.Keys(rlt.restored))
+
+		restored := NewLatencyTracker("", 4, time.Second)
+		restored.restore(rlt.restored["ord"])
+		want, got := lt.LatencyStats(), restored.LatencyStats()
+		// moved forward by the time since the snapshot
+		shift := got.Probes.LastSuccess.Sub(want.Probes.LastSuccess)
+		assert.True(t, shift >= 0 && shift < time.Second, "shift=%v", shift)
+		want.Probes.LastSuccess, got.Probes.LastSuccess = time.Time{}, time.Time{}
+		assert.Equal(t, want, got)
+		assert.Equal(t, lt.Latencies(), restored.Latencies())
+
+		// smaller window keeps newest samples
+		small := NewLatencyTracker("", 2, time.Second)
+		small.restore(rlt.restored["ord"])
+		assert.Equal(t, 2, small.nLocked())
+		assert.Equal(t, 45, small.Latency())
+		ps := small.ProbeStats()
+		ps.LastSuccess = time.Time{}
+		assert.Equal(t, ProbeStats{Successes: 1, Failures: 1, LossRate: 0.5}, ps)
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(path, []byte(`{"version":`), 0644))
+		assert.Error(t, NewRegionLatencyTracker(4, time.Second).Load(path))
+
+		assert.NoError(t, os.WriteFile(path, []byte(`{"version":1,"region":"den","time":"`+time.Now().Format(time.RFC3339)+`","peers":{"ord":{"probes":["lost"]}}}`), 0644))
+		assert.Error(t, NewRegionLatencyTracker(4, time.Second).Load(path))
+	})
+
+	t.Run("downtime", func(t *testing.T) {
+		lt := NewLatencyTracker("", 4, time.Second)
+		lt.update(10*time.Millisecond, nil)
+		lt.lastSuccess = time.Now().Add(-time.Second)
+
+		rlt := NewRegionLatencyTracker(4, time.Second)
+		rlt.trackers["ord"] = lt
+		rlt.trackers["iad"] = NewLatencyTracker("", 4, time.Second)
+		assert.NoError(t, rlt.Save(path))
+
+		// pretend the snapshot was saved an hour ago
+		j, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		var snap snapshot
+		assert.NoError(t, json.Unmarshal(j, &snap))
+		snap.Time = snap.Time.Add(-time.Hour)
+		ps := snap.Peers["ord"]
+		ps.LastSuccess = ps.LastSuccess.Add(-time.Hour)
+		snap.Peers["ord"] = ps
+		j, err = json.Marshal(snap)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(path, j, 0644))
+
+		rlt = NewRegionLatencyTracker(4, time.Second)
+		assert.NoError(t, rlt.Load(path))
+
+		restored := NewLatencyTracker("", 4, time.Second)
+		restored.restore(rlt.restored["ord"])
+		assert.Equal(t, PeerOK, restored.Status())
+
+		restored = NewLatencyTracker("", 4, time.Second)
+		restored.restore(rlt.restored["iad"])
+		assert.Equal(t, PeerUnreachable, restored.Status())
+	})
+
+	t.Run("old version", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(path, []byte(`{"version":0,"region":"den"}`), 0644))
+		assert.Error(t, NewRegionLatencyTracker(4, time.Second).Load(path))
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(path, []byte(`{"version":1,"region":"den","time":"2000-01-01T00:00:00Z"}`), 0644))
+		assert.IsError(t, NewRegionLatencyTracker(4, time.Second).Load(path), errSnapshotStale)
+	})
+}